@@ -0,0 +1,59 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"sync"
+)
+
+// maxCachedAEADs bounds the number of keys an aeadCache holds an AES-GCM instance for; when it's reached the cache
+// starts over
+const maxCachedAEADs = 64
+
+// aeadCache holds the AES-GCM instance per key, so the cipher isn't set up again for every secret; it's meant for the
+// few long-lived keys of a key provider, not for data keys that differ per secret, and is usable as its zero value
+type aeadCache struct {
+	mu    sync.RWMutex
+	aeads map[[sha256.Size]byte]cipher.AEAD
+}
+
+// get returns the cached AES-GCM instance for the key, setting it up if there isn't one
+func (c *aeadCache) get(keyBytes []byte) (aesgcm cipher.AEAD, err error) {
+
+	// the cache is keyed by a hash of the key so it doesn't hold another copy of it
+	digest := sha256.Sum256(keyBytes)
+
+	c.mu.RLock()
+	aesgcm, ok := c.aeads[digest]
+	c.mu.RUnlock()
+	if ok {
+		return aesgcm, nil
+	}
+
+	aesgcm, err = newAEAD(keyBytes)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.aeads == nil || len(c.aeads) >= maxCachedAEADs {
+		c.aeads = map[[sha256.Size]byte]cipher.AEAD{}
+	}
+	c.aeads[digest] = aesgcm
+
+	return aesgcm, nil
+}
+
+// newAEAD returns an AES-GCM instance for the key
+func newAEAD(keyBytes []byte) (aesgcm cipher.AEAD, err error) {
+
+	// The key should be the AES key, either 16 or 32 bytes to select AES-128 or AES-256.
+	block, err := aes.NewCipher(keyBytes)
+	if err != nil {
+		return
+	}
+
+	return cipher.NewGCM(block)
+}
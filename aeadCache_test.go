@@ -0,0 +1,50 @@
+package crypt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAEADCache(t *testing.T) {
+
+	t.Run("ReturnsSameAEADForSameKey", func(t *testing.T) {
+
+		cache := &aeadCache{}
+		first, err := cache.get([]byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp"))
+		assert.Nil(t, err)
+
+		// act
+		second, err := cache.get([]byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp"))
+
+		assert.Nil(t, err)
+		assert.Same(t, first, second)
+	})
+
+	t.Run("ReturnsErrorForInvalidKey", func(t *testing.T) {
+
+		cache := &aeadCache{}
+
+		// act
+		_, err := cache.get([]byte("too short"))
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("StartsOverWhenFull", func(t *testing.T) {
+
+		cache := &aeadCache{}
+		for i := 0; i < maxCachedAEADs; i++ {
+			key := []byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+			key[0] = byte(i)
+			_, err := cache.get(key)
+			assert.Nil(t, err)
+		}
+
+		// act
+		_, err := cache.get([]byte("7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot"))
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(cache.aeads))
+	})
+}
@@ -0,0 +1,211 @@
+package crypt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditOperation is the kind of operation an AuditEvent is about
+type AuditOperation string
+
+const (
+	// AuditOperationEncrypt is the encryption of a new secret
+	AuditOperationEncrypt AuditOperation = "encrypt"
+	// AuditOperationDecrypt is the decryption of a secret for a scope
+	AuditOperationDecrypt AuditOperation = "decrypt"
+	// AuditOperationReencrypt is the decryption of a secret to encrypt it again, or the encryption that follows
+	AuditOperationReencrypt AuditOperation = "reencrypt"
+	// AuditOperationRewrap is the rewrapping of the data key of a secret
+	AuditOperationRewrap AuditOperation = "rewrap"
+	// AuditOperationInspect is the opening of a secret to inspect it rather than use it, like reading the expiry time
+	// from its restrictions or fingerprinting its value
+	AuditOperationInspect AuditOperation = "inspect"
+)
+
+// AuditOutcome is the outcome of the operation an AuditEvent is about
+type AuditOutcome string
+
+const (
+	// AuditOutcomeSuccess means the operation succeeded
+	AuditOutcomeSuccess AuditOutcome = "success"
+	// AuditOutcomeDenied means the restrictions of the secret don't allow the scope, or the secret isn't valid at the time
+	AuditOutcomeDenied AuditOutcome = "denied"
+	// AuditOutcomeFailure means the operation failed, for example because the secret is malformed or its key unknown
+	AuditOutcomeFailure AuditOutcome = "failure"
+)
+
+// AuditEvent describes an operation on a secret; it never holds the plaintext of the secret, which is identified by the
+// digest of its ciphertext and, with WithFingerprintKey, by the fingerprint of its value
+type AuditEvent struct {
+	Time              time.Time      `json:"time"`
+	Operation         AuditOperation `json:"operation"`
+	Pipeline          string         `json:"pipeline,omitempty"`
+	Branch            string         `json:"branch,omitempty"`
+	Event             string         `json:"event,omitempty"`
+	Stage             string         `json:"stage,omitempty"`
+	ReleaseTarget     string         `json:"releaseTarget,omitempty"`
+	CiphertextDigest  string         `json:"ciphertextDigest,omitempty"`
+	PipelineAllowList string         `json:"pipelineAllowList,omitempty"`
+	KeyID             string         `json:"keyId,omitempty"`
+	Outcome           AuditOutcome   `json:"outcome"`
+	// Fingerprint is the fingerprint of the value, which stays the same when the secret is re-encrypted; it's only set
+	// with WithFingerprintKey and when the operation got hold of the value
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Denial is the restriction that denied the operation, if its outcome is AuditOutcomeDenied
+	Denial string `json:"denial,omitempty"`
+	// Error is the error of the operation, if its outcome is AuditOutcomeFailure
+	Error string `json:"error,omitempty"`
+}
+
+// AuditObserver receives an AuditEvent for every operation of a SecretHelper on a secret; it's called synchronously and
+// from several goroutines when WithWorkers is used, so implementations have to be quick and safe for concurrent use
+type AuditObserver interface {
+	Observe(ctx context.Context, event AuditEvent)
+}
+
+// observe passes the event for an operation on the secret to the observer, if there is one; fingerprint is the one of
+// the value, or empty if the operation didn't get hold of the value
+func (sh *secretHelperImpl) observe(ctx context.Context, operation AuditOperation, encryptedTextPlusNonce, fingerprint string, scope Scope, pipelineAllowList string, err error) {
+
+	if sh.observer == nil {
+		return
+	}
+
+	event := AuditEvent{
+		Time:              sh.now(),
+		Operation:         operation,
+		Pipeline:          scope.Pipeline,
+		Branch:            scope.Branch,
+		Event:             scope.Event,
+		Stage:             scope.Stage,
+		ReleaseTarget:     scope.ReleaseTarget,
+		CiphertextDigest:  digestCiphertext(encryptedTextPlusNonce),
+		PipelineAllowList: pipelineAllowList,
+		KeyID:             secretKeyID(encryptedTextPlusNonce),
+		Outcome:           AuditOutcomeSuccess,
+	}
+
+	var secretErr *SecretError
+	switch {
+	case err == nil:
+		event.Fingerprint = fingerprint
+	case errors.As(err, &secretErr) && secretErr.Kind != nil && isDeniedSecretError(secretErr.Kind):
+		event.Outcome = AuditOutcomeDenied
+		event.Denial = secretErr.Kind.Error()
+		if secretErr.Err != nil {
+			event.Denial = secretErr.Err.Error()
+		}
+	default:
+		event.Outcome = AuditOutcomeFailure
+		event.Error = err.Error()
+	}
+
+	sh.observer.Observe(ctx, event)
+}
+
+// digestCiphertext returns a digest that identifies the encrypted secret without revealing anything about it
+func digestCiphertext(encryptedTextPlusNonce string) string {
+
+	if encryptedTextPlusNonce == "" {
+		return ""
+	}
+	digest := sha256.Sum256([]byte(encryptedTextPlusNonce))
+
+	return hex.EncodeToString(digest[:16])
+}
+
+// secretKeyID returns the id of the key, or master key, a secret is encrypted with, or an empty string for a legacy
+// secret that doesn't identify its key
+func secretKeyID(encryptedTextPlusNonce string) string {
+
+	splittedStrings := strings.SplitN(encryptedTextPlusNonce, ".", 3)
+	if len(splittedStrings) < 3 || (splittedStrings[0] != secretFormatV2 && splittedStrings[0] != secretFormatV2WrappedKey) {
+		return ""
+	}
+
+	return splittedStrings[1]
+}
+
+// JSONLinesAuditSink is an AuditObserver that writes every event as a line of JSON
+type JSONLinesAuditSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	err    error
+}
+
+// NewJSONLinesAuditSink returns a JSONLinesAuditSink that writes to w
+func NewJSONLinesAuditSink(w io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{
+		w: w,
+	}
+}
+
+// OpenJSONLinesAuditFile returns a JSONLinesAuditSink that appends to the file, which is created if it doesn't exist;
+// Close closes the file
+func OpenJSONLinesAuditFile(path string) (*JSONLinesAuditSink, error) {
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONLinesAuditSink{
+		w:      file,
+		closer: file,
+	}, nil
+}
+
+func (s *JSONLinesAuditSink) Observe(ctx context.Context, event AuditEvent) {
+
+	line, err := json.Marshal(event)
+	if err == nil {
+		line = append(line, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// an audit trail with gaps can't be trusted, so the first error is kept for Err and Close to report
+	if s.err != nil {
+		return
+	}
+	if err == nil {
+		_, err = s.w.Write(line)
+	}
+	s.err = err
+}
+
+// Err returns the first error writing an event, if any
+func (s *JSONLinesAuditSink) Err() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Close closes the file of a sink returned by OpenJSONLinesAuditFile and returns the first error writing an event, if
+// any
+func (s *JSONLinesAuditSink) Close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closer != nil {
+		if err := s.closer.Close(); err != nil && s.err == nil {
+			s.err = err
+		}
+		s.closer = nil
+	}
+
+	return s.err
+}
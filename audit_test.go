@@ -0,0 +1,266 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingAuditObserver records the events it observes
+type recordingAuditObserver struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (o *recordingAuditObserver) Observe(ctx context.Context, event AuditEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+}
+
+func newAuditedSecretHelper(t *testing.T) (SecretHelper, *recordingAuditObserver) {
+
+	keyring := NewKeyring()
+	_, err := keyring.AddKey("key-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+	assert.Nil(t, err)
+	observer := &recordingAuditObserver{}
+	clock := func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	return NewSecretHelperWithKeyring(keyring, WithAuditObserver(observer), WithClock(clock)), observer
+}
+
+func TestWithAuditObserver(t *testing.T) {
+
+	t.Run("ObservesEncryptionOfSecret", func(t *testing.T) {
+
+		secretHelper, observer := newAuditedSecretHelper(t)
+
+		// act
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(observer.events)) {
+			assert.Equal(t, AuditOperationEncrypt, observer.events[0].Operation)
+			assert.Equal(t, AuditOutcomeSuccess, observer.events[0].Outcome)
+			assert.Equal(t, digestCiphertext(encryptedTextPlusNonce), observer.events[0].CiphertextDigest)
+			assert.Equal(t, "key-1", observer.events[0].KeyID)
+			assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", observer.events[0].PipelineAllowList)
+		}
+	})
+
+	t.Run("ObservesDecryptionOfSecretForScope", func(t *testing.T) {
+
+		secretHelper, observer := newAuditedSecretHelper(t)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = secretHelper.DecryptContext(context.Background(), encryptedTextPlusNonce, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api", Branch: "main", Stage: "deploy"})
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(observer.events)) {
+			event := observer.events[1]
+			assert.Equal(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), event.Time)
+			assert.Equal(t, AuditOperationDecrypt, event.Operation)
+			assert.Equal(t, AuditOutcomeSuccess, event.Outcome)
+			assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", event.Pipeline)
+			assert.Equal(t, "main", event.Branch)
+			assert.Equal(t, "deploy", event.Stage)
+			assert.Equal(t, digestCiphertext(encryptedTextPlusNonce), event.CiphertextDigest)
+			assert.Equal(t, "key-1", event.KeyID)
+			assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", event.PipelineAllowList)
+		}
+	})
+
+	t.Run("ObservesDenialOfSecretRestrictedToOtherPipeline", func(t *testing.T) {
+
+		secretHelper, observer := newAuditedSecretHelper(t)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-web")
+
+		assert.NotNil(t, err)
+		if assert.Equal(t, 2, len(observer.events)) {
+			assert.Equal(t, AuditOutcomeDenied, observer.events[1].Outcome)
+			assert.NotEmpty(t, observer.events[1].Denial)
+			assert.Empty(t, observer.events[1].Error)
+		}
+	})
+
+	t.Run("ObservesFailureOfMalformedSecret", func(t *testing.T) {
+
+		secretHelper, observer := newAuditedSecretHelper(t)
+
+		// act
+		_, _, err := secretHelper.Decrypt("deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u", "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.NotNil(t, err)
+		if assert.Equal(t, 1, len(observer.events)) {
+			assert.Equal(t, AuditOutcomeFailure, observer.events[0].Outcome)
+			assert.NotEmpty(t, observer.events[0].Error)
+		}
+	})
+
+	t.Run("ObservesEveryEnvelopeOfBulkDecryption", func(t *testing.T) {
+
+		secretHelper, observer := newAuditedSecretHelper(t)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		_, err = secretHelper.DecryptAllEnvelopes("a: "+envelope+", b: "+envelope, "github.com/ziplineeci/ziplinee-ci-api", WithWorkers(2))
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(observer.events))
+	})
+
+	t.Run("ObservesDecryptionAndEncryptionOfReencryption", func(t *testing.T) {
+
+		secretHelper, observer := newAuditedSecretHelper(t)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = secretHelper.ReencryptAllEnvelopes("a: "+envelope, "github.com/ziplineeci/ziplinee-ci-api", false)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 3, len(observer.events)) {
+			assert.Equal(t, AuditOperationReencrypt, observer.events[1].Operation)
+			assert.Equal(t, AuditOperationReencrypt, observer.events[2].Operation)
+			assert.NotEqual(t, observer.events[1].CiphertextDigest, observer.events[2].CiphertextDigest)
+		}
+	})
+
+	t.Run("ObservesSameFingerprintForDecryptionAndEncryptionOfReencryptionWithFingerprintKey", func(t *testing.T) {
+
+		observer := &recordingAuditObserver{}
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithAuditObserver(observer), WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = secretHelper.ReencryptAllEnvelopes("a: "+envelope, "github.com/ziplineeci/ziplinee-ci-api", false)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 3, len(observer.events)) {
+			assert.NotEmpty(t, observer.events[0].Fingerprint)
+			assert.Equal(t, observer.events[0].Fingerprint, observer.events[1].Fingerprint)
+			assert.Equal(t, observer.events[0].Fingerprint, observer.events[2].Fingerprint)
+		}
+	})
+
+	t.Run("ObservesRewrapOfSecretWithDataKey", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		observer := &recordingAuditObserver{}
+		secretHelper := NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(keyring), WithAuditObserver(observer))
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		_, err = secretHelper.RewrapAllEnvelopes("a: " + envelope)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(observer.events)) {
+			assert.Equal(t, AuditOperationRewrap, observer.events[1].Operation)
+			assert.Equal(t, "master-1", observer.events[1].KeyID)
+		}
+	})
+
+	t.Run("ObservesDecryptionServedFromCache", func(t *testing.T) {
+
+		secretHelper, observer := newAuditedSecretHelper(t)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		cachingSecretHelper := NewCachingSecretHelper(secretHelper, time.Hour, 10)
+
+		// act
+		_, err = cachingSecretHelper.DecryptAllEnvelopes("a: "+envelope+", b: "+envelope, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(observer.events))
+	})
+
+	t.Run("NeverObservesPlaintext", func(t *testing.T) {
+
+		var buffer bytes.Buffer
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithAuditObserver(NewJSONLinesAuditSink(&buffer)))
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		// act
+		_, err = secretHelper.DecryptAllEnvelopes("a: "+envelope, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.False(t, strings.Contains(buffer.String(), "this is my secret"))
+	})
+}
+
+func TestJSONLinesAuditSink(t *testing.T) {
+
+	t.Run("WritesLineOfJSONPerEvent", func(t *testing.T) {
+
+		var buffer bytes.Buffer
+		sink := NewJSONLinesAuditSink(&buffer)
+
+		// act
+		sink.Observe(context.Background(), AuditEvent{Operation: AuditOperationDecrypt, Pipeline: "github.com/ziplineeci/ziplinee-ci-api", Outcome: AuditOutcomeSuccess})
+		sink.Observe(context.Background(), AuditEvent{Operation: AuditOperationEncrypt, Outcome: AuditOutcomeFailure, Error: "failed"})
+
+		assert.Nil(t, sink.Err())
+		lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+		if assert.Equal(t, 2, len(lines)) {
+			var event AuditEvent
+			err := json.Unmarshal([]byte(lines[0]), &event)
+			assert.Nil(t, err)
+			assert.Equal(t, AuditOperationDecrypt, event.Operation)
+			assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", event.Pipeline)
+			assert.Equal(t, AuditOutcomeSuccess, event.Outcome)
+		}
+	})
+
+	t.Run("AppendsToExistingFile", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		err := os.WriteFile(path, []byte("{\"operation\":\"encrypt\",\"outcome\":\"success\"}\n"), 0600)
+		assert.Nil(t, err)
+		sink, err := OpenJSONLinesAuditFile(path)
+		assert.Nil(t, err)
+
+		// act
+		sink.Observe(context.Background(), AuditEvent{Operation: AuditOperationDecrypt, Outcome: AuditOutcomeSuccess})
+		err = sink.Close()
+
+		assert.Nil(t, err)
+		contents, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, strings.Count(string(contents), "\n"))
+	})
+
+	t.Run("ReturnsFirstWriteErrorFromClose", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		sink, err := OpenJSONLinesAuditFile(path)
+		assert.Nil(t, err)
+		err = sink.Close()
+		assert.Nil(t, err)
+
+		// act
+		sink.Observe(context.Background(), AuditEvent{Operation: AuditOperationDecrypt, Outcome: AuditOutcomeSuccess})
+
+		assert.NotNil(t, sink.Err())
+		assert.Equal(t, sink.Err(), sink.Close())
+	})
+}
@@ -0,0 +1,75 @@
+package crypt
+
+// BulkOption configures the methods that process every envelope in a text
+type BulkOption func(*bulkOptions)
+
+type bulkOptions struct {
+	collectAllErrors           bool
+	keepUndecryptableEnvelopes bool
+	allOrNothing               bool
+	targetKey                  string
+	targetKeyBase64Encoded     bool
+	targetSecretHelper         SecretHelper
+	workers                    int
+}
+
+func newBulkOptions(options []BulkOption) *bulkOptions {
+
+	o := &bulkOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	return o
+}
+
+// CollectAllErrors makes DecryptAllEnvelopes return the errors of all failing envelopes joined into a single error,
+// instead of only the last one
+func CollectAllErrors() BulkOption {
+	return func(o *bulkOptions) {
+		o.collectAllErrors = true
+	}
+}
+
+// KeepUndecryptableEnvelopes makes DecryptAllEnvelopes leave envelopes that fail to decrypt untouched, instead of
+// replacing them with an empty string
+func KeepUndecryptableEnvelopes() BulkOption {
+	return func(o *bulkOptions) {
+		o.keepUndecryptableEnvelopes = true
+	}
+}
+
+// AllOrNothing makes ReencryptAllEnvelopes return the original text and no key if any envelope fails to re-encrypt, and
+// RewrapAllEnvelopes return the original text if any envelope fails to rewrap; ReencryptAllEnvelopesStream rejects it,
+// since it writes envelopes before it has read them all
+func AllOrNothing() BulkOption {
+	return func(o *bulkOptions) {
+		o.allOrNothing = true
+	}
+}
+
+// WithTargetKey makes ReencryptAllEnvelopes re-encrypt into the given key instead of a newly generated one, so several
+// texts can be rotated to the same key
+func WithTargetKey(key string, base64encodedKey bool) BulkOption {
+	return func(o *bulkOptions) {
+		o.targetKey = key
+		o.targetKeyBase64Encoded = base64encodedKey
+	}
+}
+
+// WithTargetSecretHelper makes ReencryptAllEnvelopes re-encrypt with the EncryptEnvelope method of the target, for
+// example one backed by a keyring; no key is returned in that case
+func WithTargetSecretHelper(target SecretHelper) BulkOption {
+	return func(o *bulkOptions) {
+		o.targetSecretHelper = target
+	}
+}
+
+// WithWorkers makes DecryptAllEnvelopes, GetAllSecretValues, GetSharedSecrets, ReencryptAllEnvelopes and
+// RewrapAllEnvelopes process up to n envelopes at a time, which pays off when the key provider is slow; the output and
+// errors are in the order of the envelopes regardless
+func WithWorkers(n int) BulkOption {
+	return func(o *bulkOptions) {
+		o.workers = n
+	}
+}
@@ -0,0 +1,267 @@
+package crypt
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// CachingSecretHelper is a SecretHelper that caches decrypted values, so the same envelopes in every build of a manifest
+// are only decrypted once in a while
+type CachingSecretHelper interface {
+	SecretHelper
+	// Invalidate empties the cache, for example after a key is rotated or retired
+	Invalidate()
+}
+
+type cachingSecretHelper struct {
+	SecretHelper
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[decryptCacheKey]*list.Element
+	lru     *list.List
+}
+
+// decryptCacheKey identifies a cached value by the secret without its envelope and the scope it was decrypted for, since
+// the restrictions of a secret may allow one scope and not another
+type decryptCacheKey struct {
+	encryptedTextPlusNonce string
+	scope                  Scope
+}
+
+type decryptCacheEntry struct {
+	key               decryptCacheKey
+	value             []byte
+	pipelineAllowList string
+	expiresAt         time.Time
+}
+
+// NewCachingSecretHelper returns a CachingSecretHelper that caches up to maxEntries values decrypted by the secret helper
+// for the ttl, evicting the least recently used ones first; the cache never outlives the expiry time of a secret
+// decrypted by a secret helper of this package, and the bytes of evicted values are zeroed, although the strings
+// returned to callers can't be
+func NewCachingSecretHelper(secretHelper SecretHelper, ttl time.Duration, maxEntries int) CachingSecretHelper {
+
+	now := time.Now
+	if sh, ok := secretHelper.(*secretHelperImpl); ok {
+		now = sh.now
+	}
+
+	return &cachingSecretHelper{
+		SecretHelper: secretHelper,
+		ttl:          ttl,
+		maxEntries:   maxEntries,
+		now:          now,
+		entries:      map[decryptCacheKey]*list.Element{},
+		lru:          list.New(),
+	}
+}
+
+func (c *cachingSecretHelper) Invalidate() {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.lru.Len() > 0 {
+		c.evict(c.lru.Back())
+	}
+}
+
+func (c *cachingSecretHelper) Decrypt(encryptedTextPlusNonce, pipeline string) (decryptedText, pipelineAllowList string, err error) {
+	return c.DecryptContext(context.Background(), encryptedTextPlusNonce, pipelineScope(pipeline))
+}
+
+func (c *cachingSecretHelper) DecryptContext(ctx context.Context, encryptedTextPlusNonce string, scope Scope) (decryptedText, pipelineAllowList string, err error) {
+
+	key := decryptCacheKey{
+		encryptedTextPlusNonce: encryptedTextPlusNonce,
+		scope:                  scope,
+	}
+	if decryptedText, pipelineAllowList, ok := c.get(key); ok {
+		// a cached decryption is still a decryption as far as the audit trail is concerned
+		if sh, ok := c.SecretHelper.(*secretHelperImpl); ok {
+			sh.observe(ctx, AuditOperationDecrypt, encryptedTextPlusNonce, sh.fingerprint(decryptedText), scope, pipelineAllowList, nil)
+		}
+		return decryptedText, pipelineAllowList, nil
+	}
+
+	expiresAt := c.now().Add(c.ttl)
+	if sh, ok := c.SecretHelper.(*secretHelperImpl); ok {
+		var restrictions secretRestrictions
+		decryptedText, restrictions, err = sh.decrypt(ctx, AuditOperationDecrypt, encryptedTextPlusNonce, scope, true)
+		if err != nil {
+			return "", "", secretErrorAt(err, encryptedTextPlusNonce, 0)
+		}
+		pipelineAllowList = restrictions.PipelineAllowList
+		if restrictions.ExpiresAt != 0 && time.Unix(restrictions.ExpiresAt, 0).Before(expiresAt) {
+			expiresAt = time.Unix(restrictions.ExpiresAt, 0)
+		}
+	} else {
+		decryptedText, pipelineAllowList, err = c.SecretHelper.DecryptContext(ctx, encryptedTextPlusNonce, scope)
+		if err != nil {
+			return
+		}
+	}
+
+	c.put(key, decryptedText, pipelineAllowList, expiresAt)
+
+	return
+}
+
+func (c *cachingSecretHelper) DecryptEnvelope(encryptedTextInEnvelope, pipeline string) (decryptedText, pipelineAllowList string, err error) {
+	return c.DecryptEnvelopeContext(context.Background(), encryptedTextInEnvelope, pipelineScope(pipeline))
+}
+
+func (c *cachingSecretHelper) DecryptEnvelopeContext(ctx context.Context, encryptedTextInEnvelope string, scope Scope) (decryptedText, pipelineAllowList string, err error) {
+
+	encryptedTextPlusNonce, ok := unwrapEnvelope(encryptedTextInEnvelope)
+	if !ok {
+		return c.SecretHelper.DecryptEnvelopeContext(ctx, encryptedTextInEnvelope, scope)
+	}
+
+	decryptedText, pipelineAllowList, err = c.DecryptContext(ctx, encryptedTextPlusNonce, scope)
+	if err != nil {
+		return "", "", secretErrorAt(err, encryptedTextInEnvelope, 0)
+	}
+
+	return
+}
+
+func (c *cachingSecretHelper) DecryptAllEnvelopes(encryptedTextWithEnvelopes, pipeline string, options ...BulkOption) (decryptedText string, err error) {
+	return c.DecryptAllEnvelopesContext(context.Background(), encryptedTextWithEnvelopes, pipelineScope(pipeline), options...)
+}
+
+func (c *cachingSecretHelper) DecryptAllEnvelopesContext(ctx context.Context, encryptedTextWithEnvelopes string, scope Scope, options ...BulkOption) (decryptedText string, err error) {
+
+	o := newBulkOptions(options)
+
+	decryptedText, errs, err := replaceAllEnvelopes(ctx, encryptedTextWithEnvelopes, o.workers, func(encryptedTextInEnvelope string, offset int) (string, error) {
+		return c.bulkDecryptEnvelope(ctx, encryptedTextInEnvelope, offset, scope, o)
+	})
+	if err != nil {
+		return
+	}
+
+	return decryptedText, bulkDecryptError(o, errs)
+}
+
+func (c *cachingSecretHelper) DecryptAllEnvelopesStream(ctx context.Context, r io.Reader, w io.Writer, scope Scope, options ...BulkOption) (err error) {
+
+	o := newBulkOptions(options)
+
+	var decryptErrs []error
+	err = replaceAllEnvelopesStream(ctx, r, w, func(encryptedTextInEnvelope string, offset int) string {
+		decryptedText, err := c.bulkDecryptEnvelope(ctx, encryptedTextInEnvelope, offset, scope, o)
+		if err != nil {
+			decryptErrs = append(decryptErrs, err)
+		}
+		return decryptedText
+	})
+	if err != nil {
+		return
+	}
+
+	return bulkDecryptError(o, decryptErrs)
+}
+
+// bulkDecryptEnvelope returns what replaces an envelope when decrypting every envelope in a text, like the method of the
+// same name of secretHelperImpl
+func (c *cachingSecretHelper) bulkDecryptEnvelope(ctx context.Context, encryptedTextInEnvelope string, offset int, scope Scope, o *bulkOptions) (string, error) {
+
+	decryptedText, _, err := c.DecryptEnvelopeContext(ctx, encryptedTextInEnvelope, scope)
+	if err != nil {
+		if o.keepUndecryptableEnvelopes {
+			return encryptedTextInEnvelope, secretErrorAt(err, encryptedTextInEnvelope, offset)
+		}
+		return "", secretErrorAt(err, encryptedTextInEnvelope, offset)
+	}
+
+	return decryptedText, nil
+}
+
+func (c *cachingSecretHelper) GetAllSecretValues(input, pipeline string, options ...BulkOption) (values []string, err error) {
+	return c.GetAllSecretValuesContext(context.Background(), input, pipelineScope(pipeline), options...)
+}
+
+func (c *cachingSecretHelper) GetAllSecretValuesContext(ctx context.Context, input string, scope Scope, options ...BulkOption) (values []string, err error) {
+
+	o := newBulkOptions(options)
+
+	locations := findAllEnvelopes(input)
+	if len(locations) == 0 {
+		return
+	}
+
+	values = make([]string, len(locations))
+	errs := make([]error, len(locations))
+	err = forEachIndex(ctx, len(locations), o.workers, func(i int) {
+		values[i], _, errs[i] = c.DecryptContext(ctx, locations[i].secret(input), scope)
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	for i, err := range errs {
+		if err != nil {
+			return []string{}, secretErrorAt(err, input[locations[i].start:locations[i].end], locations[i].start)
+		}
+	}
+
+	return
+}
+
+// get returns the cached value for the key, unless it has expired
+func (c *cachingSecretHelper) get(key decryptCacheKey) (decryptedText, pipelineAllowList string, ok bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return "", "", false
+	}
+	entry := element.Value.(*decryptCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.evict(element)
+		return "", "", false
+	}
+	c.lru.MoveToFront(element)
+
+	return string(entry.value), entry.pipelineAllowList, true
+}
+
+// put caches the value until it expires, evicting the least recently used values if the cache is full
+func (c *cachingSecretHelper) put(key decryptCacheKey, decryptedText, pipelineAllowList string, expiresAt time.Time) {
+
+	if c.maxEntries < 1 || !c.now().Before(expiresAt) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.evict(element)
+	}
+	for c.lru.Len() >= c.maxEntries {
+		c.evict(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(&decryptCacheEntry{
+		key:               key,
+		value:             []byte(decryptedText),
+		pipelineAllowList: pipelineAllowList,
+		expiresAt:         expiresAt,
+	})
+}
+
+// evict removes the entry from the cache and zeroes its value
+func (c *cachingSecretHelper) evict(element *list.Element) {
+
+	entry := c.lru.Remove(element).(*decryptCacheEntry)
+	delete(c.entries, entry.key)
+	clear(entry.value)
+}
@@ -0,0 +1,155 @@
+package crypt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingKeyProvider counts the keys requested from the key provider it wraps
+type countingKeyProvider struct {
+	KeyProvider
+	calls int
+}
+
+func (p *countingKeyProvider) Key(ctx context.Context, keyID string) ([]byte, error) {
+	p.calls++
+	return p.KeyProvider.Key(ctx, keyID)
+}
+
+func newCountingSecretHelper(t *testing.T, options ...SecretHelperOption) (SecretHelper, *countingKeyProvider) {
+
+	keyring := NewKeyring()
+	_, err := keyring.AddKey("key-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+	assert.Nil(t, err)
+	keyProvider := &countingKeyProvider{KeyProvider: keyring}
+
+	return NewSecretHelperWithKeyProvider(keyProvider, options...), keyProvider
+}
+
+func TestCachingSecretHelper(t *testing.T) {
+
+	t.Run("ReturnsCachedValueWithoutDecryptingAgain", func(t *testing.T) {
+
+		secretHelper, keyProvider := newCountingSecretHelper(t)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		cachingSecretHelper := NewCachingSecretHelper(secretHelper, time.Hour, 10)
+		_, _, err = cachingSecretHelper.DecryptEnvelope(envelope, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		// act
+		decryptedText, err := cachingSecretHelper.DecryptAllEnvelopes("a: "+envelope+", b: "+envelope, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "a: this is my secret, b: this is my secret", decryptedText)
+		assert.Equal(t, 1, keyProvider.calls)
+	})
+
+	t.Run("ReturnsErrorForScopeNotAllowedBySecretDecryptedBeforeForAnotherScope", func(t *testing.T) {
+
+		secretHelper, _ := newCountingSecretHelper(t)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		cachingSecretHelper := NewCachingSecretHelper(secretHelper, time.Hour, 10)
+		_, _, err = cachingSecretHelper.DecryptEnvelope(envelope, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = cachingSecretHelper.DecryptEnvelope(envelope, "github.com/ziplineeci/ziplinee-ci-web")
+
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
+	})
+
+	t.Run("DecryptsAgainAfterTTL", func(t *testing.T) {
+
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		secretHelper, keyProvider := newCountingSecretHelper(t, WithClock(func() time.Time { return now }))
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		cachingSecretHelper := NewCachingSecretHelper(secretHelper, time.Minute, 10)
+		_, _, err = cachingSecretHelper.DecryptEnvelope(envelope, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		now = now.Add(time.Minute)
+
+		// act
+		_, _, err = cachingSecretHelper.DecryptEnvelope(envelope, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, keyProvider.calls)
+	})
+
+	t.Run("ReturnsErrSecretExpiredOnceCachedSecretExpires", func(t *testing.T) {
+
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		secretHelper, _ := newCountingSecretHelper(t, WithClock(func() time.Time { return now }))
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList, ExpiresAt(now.Add(time.Minute)))
+		assert.Nil(t, err)
+		cachingSecretHelper := NewCachingSecretHelper(secretHelper, time.Hour, 10)
+		_, _, err = cachingSecretHelper.DecryptEnvelope(envelope, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		now = now.Add(2 * time.Minute)
+
+		// act
+		_, _, err = cachingSecretHelper.DecryptEnvelope(envelope, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrSecretExpired))
+	})
+
+	t.Run("EvictsLeastRecentlyUsedValueAndZeroesIt", func(t *testing.T) {
+
+		secretHelper, keyProvider := newCountingSecretHelper(t)
+		first, err := secretHelper.Encrypt("first secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		second, err := secretHelper.Encrypt("second secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		cachingSecretHelper := NewCachingSecretHelper(secretHelper, time.Hour, 1).(*cachingSecretHelper)
+		_, _, err = cachingSecretHelper.Decrypt(first, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		firstValue := cachingSecretHelper.lru.Front().Value.(*decryptCacheEntry).value
+
+		// act
+		_, _, err = cachingSecretHelper.Decrypt(second, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		_, _, err = cachingSecretHelper.Decrypt(first, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		assert.Equal(t, 3, keyProvider.calls)
+		assert.Equal(t, make([]byte, len("first secret")), firstValue)
+		assert.Equal(t, 1, cachingSecretHelper.lru.Len())
+	})
+
+	t.Run("DecryptsAgainAfterInvalidate", func(t *testing.T) {
+
+		secretHelper, keyProvider := newCountingSecretHelper(t)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		cachingSecretHelper := NewCachingSecretHelper(secretHelper, time.Hour, 10)
+		_, _, err = cachingSecretHelper.DecryptEnvelope(envelope, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		// act
+		cachingSecretHelper.Invalidate()
+		_, _, err = cachingSecretHelper.DecryptEnvelope(envelope, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, keyProvider.calls)
+	})
+
+	t.Run("DelegatesOtherMethodsToSecretHelper", func(t *testing.T) {
+
+		secretHelper, _ := newCountingSecretHelper(t)
+		cachingSecretHelper := NewCachingSecretHelper(secretHelper, time.Hour, 10)
+
+		// act
+		envelope, err := cachingSecretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+
+		assert.Nil(t, err)
+		decryptedText, _, err := secretHelper.DecryptEnvelope(envelope, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+	})
+}
@@ -0,0 +1,660 @@
+// Command ziplinee-crypt encrypts, decrypts and rotates Ziplinee CI secrets from the command line
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	crypt "github.com/ziplineeci/ziplinee-ci-crypt"
+)
+
+// defaultKeyEnv is the environment variable the key is read from if no key file is given
+const defaultKeyEnv = "ZIPLINEE_CI_SECRET_KEY"
+
+var errUsage = errors.New("usage error")
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+var commands = []command{
+	{"encrypt", "encrypt a value from stdin or -in into an envelope", runEncrypt},
+	{"encrypt-manifest", "encrypt the values at -path and in ziplinee.plaintext() markers of a YAML or JSON document", runEncryptManifest},
+	{"decrypt", "decrypt a single envelope or secret from stdin or -in", runDecrypt},
+	{"decrypt-all", "decrypt all envelopes in a text", runDecryptAll},
+	{"reencrypt", "re-encrypt all envelopes in a text with a new or given key", runReencrypt},
+	{"generate-key", "generate a new random key", runGenerateKey},
+	{"list", "list all envelopes in a text", runList},
+	{"values", "list the decrypted values of all envelopes in a text", runValues},
+	{"check-restricted", "list all envelopes in a text that are restricted to other pipelines", runCheckRestricted},
+	{"check-expiring", "list all envelopes in a text that expire within a duration", runCheckExpiring},
+	{"is-envelope", "exit with 0 if the input is a single envelope and 1 otherwise", runIsEnvelope},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		printUsage(stderr)
+		return 2
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			err := c.run(args[1:], stdin, stdout, stderr)
+			switch {
+			case err == nil:
+				return 0
+			case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+				return 2
+			case errors.Is(err, errNoMatch):
+				return 1
+			default:
+				fmt.Fprintf(stderr, "ziplinee-crypt %v: %v\n", c.name, err)
+				return 1
+			}
+		}
+	}
+
+	fmt.Fprintf(stderr, "ziplinee-crypt: unknown command %q\n", args[0])
+	printUsage(stderr)
+
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: ziplinee-crypt <command> [flags]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-18v %v\n", c.name, c.description)
+	}
+	fmt.Fprintf(w, "\nthe key is read from -key-file or the %v environment variable; run a command with -h for its flags\n", defaultKeyEnv)
+}
+
+// errNoMatch makes a command exit with 1 without printing an error, like grep does when nothing matches
+var errNoMatch = errors.New("no match")
+
+// ioFlags holds the flags for reading input and writing output shared by all commands
+type ioFlags struct {
+	in  string
+	out string
+}
+
+func (f *ioFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.in, "in", "-", "file to read input from, - for stdin")
+	fs.StringVar(&f.out, "out", "-", "file to write output to, - for stdout")
+}
+
+func (f *ioFlags) readInput(stdin io.Reader) (string, error) {
+
+	if f.in == "-" {
+		b, err := io.ReadAll(stdin)
+		return string(b), err
+	}
+
+	b, err := os.ReadFile(f.in)
+
+	return string(b), err
+}
+
+func (f *ioFlags) writeOutput(stdout io.Writer, output string) error {
+
+	if f.out == "-" {
+		_, err := io.WriteString(stdout, output)
+		return err
+	}
+
+	return os.WriteFile(f.out, []byte(output), 0600)
+}
+
+// keyFlags holds the flags for reading the key shared by all commands that need one
+type keyFlags struct {
+	keyFile          string
+	keyEnv           string
+	base64encodedKey bool
+}
+
+func (f *keyFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.keyFile, "key-file", "", "file to read the key from")
+	fs.StringVar(&f.keyEnv, "key-env", defaultKeyEnv, "environment variable to read the key from if -key-file isn't set")
+	fs.BoolVar(&f.base64encodedKey, "base64", false, "the key is base64 encoded")
+}
+
+func (f *keyFlags) secretHelper() (crypt.SecretHelper, error) {
+
+	if f.keyFile != "" {
+		return crypt.NewSecretHelperWithKeyProvider(crypt.NewFileKeyProvider(f.keyFile, f.base64encodedKey)), nil
+	}
+	if key, ok := os.LookupEnv(f.keyEnv); !ok || key == "" {
+		return nil, fmt.Errorf("no key, set -key-file or the %v environment variable", f.keyEnv)
+	}
+
+	return crypt.NewSecretHelperWithKeyProvider(crypt.NewEnvKeyProvider(f.keyEnv, f.base64encodedKey)), nil
+}
+
+// scopeFlags holds the flags for the scope secrets are decrypted for
+type scopeFlags struct {
+	scope crypt.Scope
+}
+
+func (f *scopeFlags) register(fs *flag.FlagSet, pipelineUsage string) {
+	fs.StringVar(&f.scope.Pipeline, "pipeline", "", pipelineUsage)
+	fs.StringVar(&f.scope.Branch, "branch", "", "branch the build or release runs for")
+	fs.StringVar(&f.scope.Event, "event", "", "event that triggered the build or release, like push or release")
+	fs.StringVar(&f.scope.Stage, "stage", "", "name of the stage")
+	fs.StringVar(&f.scope.ReleaseTarget, "release-target", "", "release target, empty for builds")
+}
+
+// restrictionFlags holds the flags for restricting an encrypted secret further than its pipeline allow list
+type restrictionFlags struct {
+	branches       string
+	events         string
+	stages         string
+	releaseTargets string
+	notBefore      string
+	expiresAt      string
+}
+
+func (f *restrictionFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.branches, "branches", "", "comma separated branches or globs allowed to decrypt the secret")
+	fs.StringVar(&f.events, "events", "", "comma separated events allowed to decrypt the secret, like push or release")
+	fs.StringVar(&f.stages, "stages", "", "comma separated stages allowed to decrypt the secret")
+	fs.StringVar(&f.releaseTargets, "release-targets", "", "comma separated release targets allowed to decrypt the secret")
+	fs.StringVar(&f.notBefore, "not-before", "", "RFC 3339 time before which the secret doesn't decrypt")
+	fs.StringVar(&f.expiresAt, "expires-at", "", "RFC 3339 time from which on the secret doesn't decrypt")
+}
+
+func (f *restrictionFlags) encryptOptions() (options []crypt.EncryptOption, err error) {
+
+	if f.branches != "" {
+		options = append(options, crypt.RestrictToBranches(strings.Split(f.branches, ",")...))
+	}
+	if f.events != "" {
+		options = append(options, crypt.RestrictToEvents(strings.Split(f.events, ",")...))
+	}
+	if f.stages != "" {
+		options = append(options, crypt.RestrictToStages(strings.Split(f.stages, ",")...))
+	}
+	if f.releaseTargets != "" {
+		options = append(options, crypt.RestrictToReleaseTargets(strings.Split(f.releaseTargets, ",")...))
+	}
+	if f.notBefore != "" {
+		notBefore, err := time.Parse(time.RFC3339, f.notBefore)
+		if err != nil {
+			return nil, fmt.Errorf("-not-before: %w", err)
+		}
+		options = append(options, crypt.NotBefore(notBefore))
+	}
+	if f.expiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, f.expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("-expires-at: %w", err)
+		}
+		options = append(options, crypt.ExpiresAt(expiresAt))
+	}
+
+	return
+}
+
+// pathsFlag collects the values of a flag that can be repeated
+type pathsFlag []string
+
+func (f *pathsFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *pathsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func readKey(keyFile string) (string, error) {
+
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return "", err
+	}
+
+	return trimNewline(string(b)), nil
+}
+
+// trimNewline removes a single trailing newline, as added by echo or editors, but no other whitespace
+func trimNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("ziplinee-crypt "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments %v\n", fs.Args())
+		fs.Usage()
+		return errUsage
+	}
+
+	return nil
+}
+
+func runEncrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("encrypt", stderr)
+	var kf keyFlags
+	var iof ioFlags
+	var rf restrictionFlags
+	kf.register(fs)
+	iof.register(fs)
+	rf.register(fs)
+	pipelineAllowList := fs.String("allow-list", crypt.DefaultPipelineAllowList, "comma separated pipelines allowed to decrypt the secret: exact names, globs like github.com/org/* or a last entry prefixed with regex:")
+	raw := fs.Bool("raw", false, "output the secret without ziplinee.secret() envelope")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	encryptOptions, err := rf.encryptOptions()
+	if err != nil {
+		return err
+	}
+	secretHelper, err := kf.secretHelper()
+	if err != nil {
+		return err
+	}
+	input, err := iof.readInput(stdin)
+	if err != nil {
+		return err
+	}
+
+	var encryptedText string
+	if *raw {
+		encryptedText, err = secretHelper.Encrypt(trimNewline(input), *pipelineAllowList, encryptOptions...)
+	} else {
+		encryptedText, err = secretHelper.EncryptEnvelope(trimNewline(input), *pipelineAllowList, encryptOptions...)
+	}
+	if err != nil {
+		return err
+	}
+
+	return iof.writeOutput(stdout, encryptedText+"\n")
+}
+
+func runEncryptManifest(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("encrypt-manifest", stderr)
+	var kf keyFlags
+	var iof ioFlags
+	var rf restrictionFlags
+	var paths pathsFlag
+	kf.register(fs)
+	iof.register(fs)
+	rf.register(fs)
+	pipelineAllowList := fs.String("allow-list", crypt.DefaultPipelineAllowList, "comma separated pipelines allowed to decrypt the secrets: exact names, globs like github.com/org/* or a last entry prefixed with regex:")
+	fs.Var(&paths, "path", "path of a value to encrypt, like stages.deploy.env.TOKEN or credentials[0].token; can be repeated")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	encryptOptions, err := rf.encryptOptions()
+	if err != nil {
+		return err
+	}
+	secretHelper, err := kf.secretHelper()
+	if err != nil {
+		return err
+	}
+	input, err := iof.readInput(stdin)
+	if err != nil {
+		return err
+	}
+
+	encryptedManifest, err := crypt.EncryptManifest(context.Background(), secretHelper, input, *pipelineAllowList, paths, encryptOptions...)
+	if err != nil {
+		return err
+	}
+
+	return iof.writeOutput(stdout, encryptedManifest)
+}
+
+func runDecrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("decrypt", stderr)
+	var kf keyFlags
+	var iof ioFlags
+	kf.register(fs)
+	iof.register(fs)
+	var sf scopeFlags
+	sf.register(fs, "pipeline the secret is decrypted for")
+	showAllowList := fs.Bool("show-allow-list", false, "output the pipeline allow list of the secret instead of its value")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	secretHelper, err := kf.secretHelper()
+	if err != nil {
+		return err
+	}
+	input, err := iof.readInput(stdin)
+	if err != nil {
+		return err
+	}
+	input = strings.TrimSpace(input)
+
+	var decryptedText, pipelineAllowList string
+	if secretHelper.IsEncryptedEnvelope(input) {
+		decryptedText, pipelineAllowList, err = secretHelper.DecryptEnvelopeContext(context.Background(), input, sf.scope)
+	} else {
+		decryptedText, pipelineAllowList, err = secretHelper.DecryptContext(context.Background(), input, sf.scope)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *showAllowList {
+		return iof.writeOutput(stdout, pipelineAllowList+"\n")
+	}
+
+	return iof.writeOutput(stdout, decryptedText)
+}
+
+func runDecryptAll(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("decrypt-all", stderr)
+	var kf keyFlags
+	var iof ioFlags
+	kf.register(fs)
+	iof.register(fs)
+	var sf scopeFlags
+	sf.register(fs, "pipeline the secrets are decrypted for")
+	collectAllErrors := fs.Bool("collect-all-errors", false, "report all failing envelopes instead of only the last one")
+	keepUndecryptable := fs.Bool("keep-undecryptable", false, "leave envelopes that fail to decrypt untouched")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	secretHelper, err := kf.secretHelper()
+	if err != nil {
+		return err
+	}
+	input, err := iof.readInput(stdin)
+	if err != nil {
+		return err
+	}
+
+	var options []crypt.BulkOption
+	if *collectAllErrors {
+		options = append(options, crypt.CollectAllErrors())
+	}
+	if *keepUndecryptable {
+		options = append(options, crypt.KeepUndecryptableEnvelopes())
+	}
+
+	decryptedText, err := secretHelper.DecryptAllEnvelopesContext(context.Background(), input, sf.scope, options...)
+	if err != nil {
+		return err
+	}
+
+	return iof.writeOutput(stdout, decryptedText)
+}
+
+func runReencrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("reencrypt", stderr)
+	var kf keyFlags
+	var iof ioFlags
+	kf.register(fs)
+	iof.register(fs)
+	pipeline := fs.String("pipeline", "", "pipeline the secrets are decrypted for, restrictions are carried over regardless")
+	newKeyFile := fs.String("new-key-file", "", "file to write the newly generated key to")
+	newKeyBase64 := fs.Bool("new-key-base64", false, "base64 encode the newly generated key")
+	targetKeyFile := fs.String("target-key-file", "", "file to read the key to re-encrypt into, instead of generating a new one")
+	targetKeyBase64 := fs.Bool("target-key-base64", false, "the target key is base64 encoded")
+	allOrNothing := fs.Bool("all-or-nothing", false, "leave the input untouched if any envelope fails to re-encrypt")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *newKeyFile == "" && *targetKeyFile == "" {
+		fmt.Fprintf(stderr, "either -new-key-file or -target-key-file is required\n")
+		fs.Usage()
+		return errUsage
+	}
+
+	secretHelper, err := kf.secretHelper()
+	if err != nil {
+		return err
+	}
+	input, err := iof.readInput(stdin)
+	if err != nil {
+		return err
+	}
+
+	var options []crypt.BulkOption
+	if *allOrNothing {
+		options = append(options, crypt.AllOrNothing())
+	}
+	if *targetKeyFile != "" {
+		targetKey, err := readKey(*targetKeyFile)
+		if err != nil {
+			return err
+		}
+		options = append(options, crypt.WithTargetKey(targetKey, *targetKeyBase64))
+	}
+
+	report, reencryptErr := secretHelper.ReencryptAllEnvelopesWithReport(input, *pipeline, *newKeyBase64, options...)
+	for _, envelope := range report.Envelopes {
+		if envelope.Err != nil {
+			fmt.Fprintf(stderr, "offset %v: not re-encrypted: %v\n", envelope.Offset, envelope.Err)
+		}
+	}
+	if report.Key == "" {
+		return reencryptErr
+	}
+
+	if *targetKeyFile == "" {
+		if err := os.WriteFile(*newKeyFile, []byte(report.Key), 0600); err != nil {
+			return err
+		}
+	}
+	if err := iof.writeOutput(stdout, report.ReencryptedText); err != nil {
+		return err
+	}
+
+	return reencryptErr
+}
+
+func runGenerateKey(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("generate-key", stderr)
+	var iof ioFlags
+	fs.StringVar(&iof.out, "out", "-", "file to write the key to, - for stdout")
+	numberOfBytes := fs.Int("bytes", 32, "number of bytes, 16 or 32 to select AES-128 or AES-256")
+	base64encodedKey := fs.Bool("base64", false, "base64 encode the key")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	key, err := crypt.GenerateKey(*numberOfBytes, *base64encodedKey)
+	if err != nil {
+		return err
+	}
+
+	return iof.writeOutput(stdout, key)
+}
+
+func runList(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("list", stderr)
+	var iof ioFlags
+	iof.register(fs)
+	secrets := fs.Bool("secrets", false, "list the secrets without their ziplinee.secret() envelope")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	input, err := iof.readInput(stdin)
+	if err != nil {
+		return err
+	}
+
+	var envelopes []string
+	if *secrets {
+		envelopes, err = crypt.GetAllSecrets(input)
+	} else {
+		envelopes, err = crypt.GetAllSecretEnvelopes(input)
+	}
+	if err != nil {
+		return err
+	}
+
+	return iof.writeOutput(stdout, joinLines(envelopes))
+}
+
+func runValues(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("values", stderr)
+	var kf keyFlags
+	var iof ioFlags
+	kf.register(fs)
+	iof.register(fs)
+	var sf scopeFlags
+	sf.register(fs, "pipeline the secrets are decrypted for")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	secretHelper, err := kf.secretHelper()
+	if err != nil {
+		return err
+	}
+	input, err := iof.readInput(stdin)
+	if err != nil {
+		return err
+	}
+
+	values, err := secretHelper.GetAllSecretValuesContext(context.Background(), input, sf.scope)
+	if err != nil {
+		return err
+	}
+
+	return iof.writeOutput(stdout, joinLines(values))
+}
+
+func runCheckRestricted(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("check-restricted", stderr)
+	var kf keyFlags
+	var iof ioFlags
+	kf.register(fs)
+	iof.register(fs)
+	pipeline := fs.String("pipeline", "", "pipeline to check the secrets for")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	secretHelper, err := kf.secretHelper()
+	if err != nil {
+		return err
+	}
+	input, err := iof.readInput(stdin)
+	if err != nil {
+		return err
+	}
+
+	invalidSecrets, err := secretHelper.GetInvalidRestrictedSecrets(input, *pipeline)
+	if err != nil && !errors.Is(err, crypt.ErrRestrictedSecret) {
+		return err
+	}
+	if err := iof.writeOutput(stdout, joinLines(invalidSecrets)); err != nil {
+		return err
+	}
+	if len(invalidSecrets) > 0 {
+		return fmt.Errorf("%v secrets are restricted to other pipelines than %v", len(invalidSecrets), *pipeline)
+	}
+
+	return nil
+}
+
+func runCheckExpiring(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("check-expiring", stderr)
+	var kf keyFlags
+	var iof ioFlags
+	kf.register(fs)
+	iof.register(fs)
+	within := fs.Duration("within", 30*24*time.Hour, "list secrets that expire within this duration, including expired ones")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	secretHelper, err := kf.secretHelper()
+	if err != nil {
+		return err
+	}
+	input, err := iof.readInput(stdin)
+	if err != nil {
+		return err
+	}
+
+	expiringSecrets, err := secretHelper.GetExpiringSecrets(input, *within)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, expiringSecret := range expiringSecrets {
+		lines = append(lines, fmt.Sprintf("%v %v", expiringSecret.ExpiresAt.UTC().Format(time.RFC3339), expiringSecret.Envelope))
+	}
+	if err := iof.writeOutput(stdout, joinLines(lines)); err != nil {
+		return err
+	}
+	if len(expiringSecrets) > 0 {
+		return fmt.Errorf("%v secrets expire within %v", len(expiringSecrets), *within)
+	}
+
+	return nil
+}
+
+func runIsEnvelope(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+
+	fs := newFlagSet("is-envelope", stderr)
+	var iof ioFlags
+	fs.StringVar(&iof.in, "in", "-", "file to read input from, - for stdin")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	input, err := iof.readInput(stdin)
+	if err != nil {
+		return err
+	}
+
+	if !crypt.IsEncryptedEnvelope(strings.TrimSpace(input)) {
+		return errNoMatch
+	}
+
+	return nil
+}
+
+func joinLines(lines []string) string {
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	crypt "github.com/ziplineeci/ziplinee-ci-crypt"
+)
+
+func TestRun(t *testing.T) {
+
+	t.Run("ReturnsUsageErrorIfNoCommandIsGiven", func(t *testing.T) {
+
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{}, strings.NewReader(""), &stdout, &stderr)
+
+		assert.Equal(t, 2, exitCode)
+		assert.Contains(t, stderr.String(), "usage: ziplinee-crypt")
+	})
+
+	t.Run("ReturnsUsageErrorIfCommandIsUnknown", func(t *testing.T) {
+
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"unknown"}, strings.NewReader(""), &stdout, &stderr)
+
+		assert.Equal(t, 2, exitCode)
+	})
+
+	t.Run("EncryptsValueFromStdinWithKeyFromEnvironmentVariable", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"encrypt", "-allow-list", "github.com/ziplineeci/ziplinee-ci-api"}, strings.NewReader("this is my secret\n"), &stdout, &stderr)
+
+		assert.Equal(t, 0, exitCode, stderr.String())
+		decryptedText, pipelineAllowList, err := crypt.NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false).DecryptEnvelope(strings.TrimSpace(stdout.String()), "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", pipelineAllowList)
+	})
+
+	t.Run("EncryptsValuesAtPathsAndPlaintextMarkersOfManifestInPlace", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		manifestFile := filepath.Join(t.TempDir(), ".ziplinee.yaml")
+		err := os.WriteFile(manifestFile, []byte("env:\n  TOKEN: this is my secret # the token\n  PASSWORD: ziplinee.plaintext(my password)\n  OTHER: not a secret\n"), 0600)
+		assert.Nil(t, err)
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"encrypt-manifest", "-in", manifestFile, "-out", manifestFile, "-path", "env.TOKEN", "-allow-list", "github.com/ziplineeci/ziplinee-ci-api"}, strings.NewReader(""), &stdout, &stderr)
+
+		assert.Equal(t, 0, exitCode, stderr.String())
+		encryptedManifest, err := os.ReadFile(manifestFile)
+		assert.Nil(t, err)
+		assert.Contains(t, string(encryptedManifest), "  OTHER: not a secret\n")
+		values, err := crypt.NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false).GetAllSecretValues(string(encryptedManifest), "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"this is my secret", "my password"}, values)
+	})
+
+	t.Run("ReturnsErrorIfPathOfManifestIsNotFound", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"encrypt-manifest", "-path", "env.MISSING"}, strings.NewReader("env:\n  TOKEN: this is my secret\n"), &stdout, &stderr)
+
+		assert.Equal(t, 1, exitCode)
+		assert.Contains(t, stderr.String(), crypt.ErrManifestPathNotFound.Error())
+	})
+
+	t.Run("ReturnsErrorWithoutOutputIfPlaintextMarkerIsNotClosed", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"encrypt-manifest"}, strings.NewReader("env:\n  TOKEN: ziplinee.plaintext(abc\n"), &stdout, &stderr)
+
+		assert.Equal(t, 1, exitCode)
+		assert.Equal(t, "", stdout.String())
+		assert.Contains(t, stderr.String(), "env.TOKEN")
+	})
+
+	t.Run("DecryptsEnvelopeWithKeyFromFile", func(t *testing.T) {
+
+		keyFile := filepath.Join(t.TempDir(), "key")
+		err := os.WriteFile(keyFile, []byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp\n"), 0600)
+		assert.Nil(t, err)
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"decrypt", "-key-file", keyFile, "-pipeline", "github.com/ziplineeci/ziplinee-ci-api"}, strings.NewReader("ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)\n"), &stdout, &stderr)
+
+		assert.Equal(t, 0, exitCode, stderr.String())
+		assert.Equal(t, "this is my secret", stdout.String())
+	})
+
+	t.Run("ReturnsErrorIfSecretIsRestrictedToOtherPipeline", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"decrypt", "-pipeline", "github.com/ziplineeci/ziplinee-ci-web"}, strings.NewReader("n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do="), &stdout, &stderr)
+
+		assert.Equal(t, 1, exitCode)
+		assert.Equal(t, "", stdout.String())
+		assert.Contains(t, stderr.String(), crypt.ErrRestrictedSecret.Error())
+	})
+
+	t.Run("ReturnsErrorIfNoKeyIsSet", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "")
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"decrypt"}, strings.NewReader("MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P"), &stdout, &stderr)
+
+		assert.Equal(t, 1, exitCode)
+		assert.Contains(t, stderr.String(), "no key")
+	})
+
+	t.Run("DecryptsAllEnvelopesFromFileIntoFile", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "in"), []byte("token: ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)\n"), 0600)
+		assert.Nil(t, err)
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"decrypt-all", "-in", filepath.Join(dir, "in"), "-out", filepath.Join(dir, "out")}, strings.NewReader(""), &stdout, &stderr)
+
+		assert.Equal(t, 0, exitCode, stderr.String())
+		out, err := os.ReadFile(filepath.Join(dir, "out"))
+		assert.Nil(t, err)
+		assert.Equal(t, "token: this is my secret\n", string(out))
+	})
+
+	t.Run("ReencryptsAllEnvelopesAndWritesNewKey", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		newKeyFile := filepath.Join(t.TempDir(), "newkey")
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"reencrypt", "-new-key-file", newKeyFile, "-new-key-base64"}, strings.NewReader("token: ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)\n"), &stdout, &stderr)
+
+		assert.Equal(t, 0, exitCode, stderr.String())
+		newKey, err := os.ReadFile(newKeyFile)
+		assert.Nil(t, err)
+		decryptedText, err := crypt.NewSecretHelper(string(newKey), true).DecryptAllEnvelopes(stdout.String(), "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		assert.Equal(t, "token: this is my secret\n", decryptedText)
+	})
+
+	t.Run("ReturnsUsageErrorIfReencryptHasNoKeyDestination", func(t *testing.T) {
+
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"reencrypt"}, strings.NewReader(""), &stdout, &stderr)
+
+		assert.Equal(t, 2, exitCode)
+	})
+
+	t.Run("GeneratesBase64EncodedKey", func(t *testing.T) {
+
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"generate-key", "-base64"}, strings.NewReader(""), &stdout, &stderr)
+
+		assert.Equal(t, 0, exitCode, stderr.String())
+		assert.Equal(t, 44, len(stdout.String()))
+	})
+
+	t.Run("ListsAllEnvelopes", func(t *testing.T) {
+
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"list", "-secrets"}, strings.NewReader("a: ziplinee.secret(abc.def)\nb: ziplinee.secret(ghi.jkl)\n"), &stdout, &stderr)
+
+		assert.Equal(t, 0, exitCode, stderr.String())
+		assert.Equal(t, "abc.def\nghi.jkl\n", stdout.String())
+	})
+
+	t.Run("ListsRestrictedSecretsAndReturnsError", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"check-restricted", "-pipeline", "github.com/ziplineeci/ziplinee-ci-web"}, strings.NewReader("ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P) ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=)"), &stdout, &stderr)
+
+		assert.Equal(t, 1, exitCode)
+		assert.Equal(t, "ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=)\n", stdout.String())
+	})
+
+	t.Run("ReturnsZeroForEnvelope", func(t *testing.T) {
+
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"is-envelope"}, strings.NewReader("ziplinee.secret(abc.def)\n"), &stdout, &stderr)
+
+		assert.Equal(t, 0, exitCode)
+	})
+
+	t.Run("ReturnsOneWithoutErrorForNonEnvelope", func(t *testing.T) {
+
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"is-envelope"}, strings.NewReader("abc.def"), &stdout, &stderr)
+
+		assert.Equal(t, 1, exitCode)
+		assert.Equal(t, "", stderr.String())
+	})
+
+	t.Run("ReturnsOneWithoutErrorForEmptyInput", func(t *testing.T) {
+
+		var stdout, stderr bytes.Buffer
+
+		// act
+		exitCode := run([]string{"is-envelope"}, strings.NewReader(""), &stdout, &stderr)
+
+		assert.Equal(t, 1, exitCode)
+		assert.Equal(t, "", stderr.String())
+	})
+
+	t.Run("DecryptsSecretRestrictedToBranchOnlyForThatBranch", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		var envelope, stderr bytes.Buffer
+		exitCode := run([]string{"encrypt", "-allow-list", "github.com/ziplineeci/*", "-branches", "main,release/*", "-events", "release"}, strings.NewReader("this is my secret"), &envelope, &stderr)
+		assert.Equal(t, 0, exitCode, stderr.String())
+		var stdout bytes.Buffer
+
+		// act
+		exitCode = run([]string{"decrypt", "-pipeline", "github.com/ziplineeci/ziplinee-ci-api", "-branch", "release/1.0", "-event", "release"}, bytes.NewReader(envelope.Bytes()), &stdout, &stderr)
+
+		assert.Equal(t, 0, exitCode, stderr.String())
+		assert.Equal(t, "this is my secret", stdout.String())
+		exitCode = run([]string{"decrypt", "-pipeline", "github.com/ziplineeci/ziplinee-ci-api", "-branch", "feature", "-event", "release"}, bytes.NewReader(envelope.Bytes()), &stdout, &stderr)
+		assert.Equal(t, 1, exitCode)
+		assert.Contains(t, stderr.String(), "branch \"feature\" isn't allowed")
+	})
+
+	t.Run("ChecksExpiringSecretsAndFailsIfAnyExpireWithinDuration", func(t *testing.T) {
+
+		t.Setenv(defaultKeyEnv, "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		var envelope, stderr bytes.Buffer
+		expiresAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+		exitCode := run([]string{"encrypt", "-expires-at", expiresAt.Format(time.RFC3339)}, strings.NewReader("this is my secret"), &envelope, &stderr)
+		assert.Equal(t, 0, exitCode, stderr.String())
+		var stdout bytes.Buffer
+
+		// act
+		exitCode = run([]string{"check-expiring", "-within", "72h"}, bytes.NewReader(envelope.Bytes()), &stdout, &stderr)
+
+		assert.Equal(t, 1, exitCode)
+		assert.Equal(t, expiresAt.Format(time.RFC3339)+" "+envelope.String(), stdout.String())
+		stdout.Reset()
+		exitCode = run([]string{"check-expiring", "-within", "24h"}, bytes.NewReader(envelope.Bytes()), &stdout, &stderr)
+		assert.Equal(t, 0, exitCode)
+		assert.Equal(t, "", stdout.String())
+	})
+}
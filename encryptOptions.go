@@ -0,0 +1,51 @@
+package crypt
+
+import "time"
+
+// EncryptOption restricts an encrypted secret further than its pipeline allow list; every restriction is a list of exact
+// values and globs in which '*' doesn't match a '/', and a secret only decrypts for a scope that matches all of them
+type EncryptOption func(*secretRestrictions)
+
+// RestrictToBranches restricts a secret to builds and releases of the given branches, like main or release/*
+func RestrictToBranches(branches ...string) EncryptOption {
+	return func(r *secretRestrictions) {
+		r.Branches = append(r.Branches, branches...)
+	}
+}
+
+// RestrictToEvents restricts a secret to builds and releases triggered by the given events, like push or release
+func RestrictToEvents(events ...string) EncryptOption {
+	return func(r *secretRestrictions) {
+		r.Events = append(r.Events, events...)
+	}
+}
+
+// RestrictToStages restricts a secret to the stages with the given names
+func RestrictToStages(stages ...string) EncryptOption {
+	return func(r *secretRestrictions) {
+		r.Stages = append(r.Stages, stages...)
+	}
+}
+
+// RestrictToReleaseTargets restricts a secret to releases to the given release targets, so builds can't decrypt it
+func RestrictToReleaseTargets(releaseTargets ...string) EncryptOption {
+	return func(r *secretRestrictions) {
+		r.ReleaseTargets = append(r.ReleaseTargets, releaseTargets...)
+	}
+}
+
+// NotBefore makes a secret fail to decrypt with ErrSecretNotYetValid before the given time; it's stored with a
+// precision of seconds
+func NotBefore(t time.Time) EncryptOption {
+	return func(r *secretRestrictions) {
+		r.NotBefore = t.Unix()
+	}
+}
+
+// ExpiresAt makes a secret fail to decrypt with ErrSecretExpired from the given time on, for temporary credentials;
+// it's stored with a precision of seconds
+func ExpiresAt(t time.Time) EncryptOption {
+	return func(r *secretRestrictions) {
+		r.ExpiresAt = t.Unix()
+	}
+}
@@ -0,0 +1,118 @@
+package crypt
+
+import (
+	"bytes"
+	"strings"
+)
+
+// secretEnvelopePrefix starts every secret envelope, see SecretEnvelopeRegex
+const secretEnvelopePrefix = "ziplinee.secret("
+
+// envelopeLocation is the byte range of an envelope in a text
+type envelopeLocation struct {
+	start, end int
+}
+
+// secret returns the secret inside the envelope at the location in the input
+func (l envelopeLocation) secret(input string) string {
+	return input[l.start+len(secretEnvelopePrefix) : l.end-1]
+}
+
+// findAllEnvelopes returns the locations of the envelopes in the input, the same ones SecretEnvelopeRegex matches; it
+// scans the input once without backtracking, which is a lot faster than the regular expression
+func findAllEnvelopes(input string) (locations []envelopeLocation) {
+
+	for offset := 0; ; {
+		textEnd, envelopeEnd := nextEnvelope(input[offset:], true, 0)
+		if envelopeEnd == 0 {
+			return
+		}
+		locations = append(locations, envelopeLocation{start: offset + textEnd, end: offset + envelopeEnd})
+		offset += envelopeEnd
+	}
+}
+
+// unwrapEnvelope returns the secret inside the envelope, or false if the text isn't exactly one envelope
+func unwrapEnvelope(s string) (secret string, ok bool) {
+
+	textEnd, envelopeEnd := nextEnvelope(s, true, 0)
+	if envelopeEnd == 0 || textEnd != 0 || envelopeEnd != len(s) {
+		return "", false
+	}
+
+	return envelopeLocation{start: 0, end: envelopeEnd}.secret(s), true
+}
+
+// nextEnvelope returns the end of the text that precedes the next envelope and the end of that envelope, or an envelope
+// end of 0 if there's no envelope; unless the input is at its end, the part after the text end then may be the start of
+// an envelope that's completed by the next read; envelopes longer than the maximum length, if it isn't 0, are treated
+// as text
+func nextEnvelope[T string | []byte](b T, atEOF bool, maxLength int) (textEnd, envelopeEnd int) {
+
+	for i := 0; ; {
+		j := indexEnvelopePrefix(b[i:])
+		if j < 0 {
+			if atEOF {
+				return len(b), 0
+			}
+			return len(b) - partialPrefixLength(b[i:]), 0
+		}
+
+		start := i + j
+		end := start + len(secretEnvelopePrefix)
+		for end < len(b) && isSecretByte(b[end]) {
+			end++
+		}
+		switch {
+		case maxLength > 0 && end-start >= maxLength:
+			// too long to hold, copy it as text
+		case end == len(b) && !atEOF:
+			return start, 0
+		case end < len(b) && b[end] == ')' && end > start+len(secretEnvelopePrefix):
+			return start, end + 1
+		}
+
+		// not an envelope; the prefix can't occur again before the end of the scanned bytes, since they don't contain
+		// a '('
+		i = start + len(secretEnvelopePrefix)
+	}
+}
+
+// indexEnvelopePrefix returns the index of the first envelope prefix in the bytes, or -1 if there's none
+func indexEnvelopePrefix[T string | []byte](b T) int {
+
+	// the standard library's index functions are vectorized, which makes them a lot faster than a loop
+	switch b := any(b).(type) {
+	case string:
+		return strings.Index(b, secretEnvelopePrefix)
+	case []byte:
+		return bytes.Index(b, []byte(secretEnvelopePrefix))
+	}
+
+	return -1
+}
+
+// partialPrefixLength returns the length of the longest end of the bytes that starts an envelope prefix
+func partialPrefixLength[T string | []byte](b T) int {
+
+	for n := min(len(b), len(secretEnvelopePrefix)-1); n > 0; n-- {
+		if string(b[len(b)-n:]) == secretEnvelopePrefix[:n] {
+			return n
+		}
+	}
+
+	return 0
+}
+
+// secretBytes holds the bytes that can occur in a secret inside an envelope, see SecretEnvelopeRegex
+var secretBytes = func() (table [256]bool) {
+	for _, b := range []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.=_-") {
+		table[b] = true
+	}
+	return
+}()
+
+// isSecretByte returns whether the byte can occur in a secret inside an envelope
+func isSecretByte(b byte) bool {
+	return secretBytes[b]
+}
@@ -0,0 +1,101 @@
+package crypt
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindAllEnvelopes(t *testing.T) {
+
+	t.Run("ReturnsSameLocationsAsSecretEnvelopeRegex", func(t *testing.T) {
+
+		r := regexp.MustCompile(SecretEnvelopeRegex)
+		inputs := []string{
+			"",
+			"key: value",
+			"ziplinee.secret(abc)",
+			"a: ziplinee.secret(abc) b: ziplinee.secret(d.e=f_g-h)",
+			"ziplinee.secret()",
+			"ziplinee.secret(abc",
+			"ziplinee.secret(ab c)",
+			"ziplinee.secret(abcziplinee.secret(def)",
+			"ziplinee.secret(ziplinee.secret(def))",
+			"zziplinee.secret(abc)ziplinee.secret(def)",
+			"ziplinee.secret(abc))",
+			"ziplinee.secre(abc) ziplinee.secret(abc)",
+			"ziplinee.secret(a/b) ziplinee.secret(a+b) ziplinee.secret(a\nb)",
+		}
+
+		for _, input := range inputs {
+
+			// act
+			locations := findAllEnvelopes(input)
+
+			var indexes [][]int
+			for _, loc := range locations {
+				indexes = append(indexes, []int{loc.start, loc.end})
+			}
+			assert.Equal(t, r.FindAllStringIndex(input, -1), indexes, input)
+		}
+	})
+}
+
+func TestNextEnvelope(t *testing.T) {
+
+	t.Run("HoldsBackPartialPrefixIfNotAtEOF", func(t *testing.T) {
+
+		// act
+		textEnd, envelopeEnd := nextEnvelope([]byte("key: ziplinee.sec"), false, 0)
+
+		assert.Equal(t, 5, textEnd)
+		assert.Equal(t, 0, envelopeEnd)
+	})
+
+	t.Run("HoldsBackIncompleteEnvelopeIfNotAtEOF", func(t *testing.T) {
+
+		// act
+		textEnd, envelopeEnd := nextEnvelope([]byte("key: ziplinee.secret(abc"), false, 0)
+
+		assert.Equal(t, 5, textEnd)
+		assert.Equal(t, 0, envelopeEnd)
+	})
+
+	t.Run("TreatsEnvelopeLongerThanMaximumLengthAsText", func(t *testing.T) {
+
+		// act
+		textEnd, envelopeEnd := nextEnvelope("key: ziplinee.secret(abc)", true, 19)
+
+		assert.Equal(t, 25, textEnd)
+		assert.Equal(t, 0, envelopeEnd)
+	})
+}
+
+func TestUnwrapEnvelope(t *testing.T) {
+
+	t.Run("ReturnsSecretInsideEnvelope", func(t *testing.T) {
+
+		// act
+		secret, ok := unwrapEnvelope("ziplinee.secret(abc.def)")
+
+		assert.True(t, ok)
+		assert.Equal(t, "abc.def", secret)
+	})
+
+	t.Run("ReturnsFalseIfTextIsMoreThanEnvelope", func(t *testing.T) {
+
+		// act
+		_, ok := unwrapEnvelope(" ziplinee.secret(abc.def)")
+
+		assert.False(t, ok)
+	})
+
+	t.Run("ReturnsFalseForEmptyText", func(t *testing.T) {
+
+		// act
+		_, ok := unwrapEnvelope("")
+
+		assert.False(t, ok)
+	})
+}
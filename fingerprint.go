@@ -0,0 +1,110 @@
+package crypt
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrNoFingerprintKey is returned when a fingerprint is requested from a SecretHelper created without WithFingerprintKey
+var ErrNoFingerprintKey = errors.New("no fingerprint key is set")
+
+// minFingerprintKeySize is the minimum size of a fingerprint key, so fingerprints can't be brute forced by guessing
+// the key
+const minFingerprintKeySize = 16
+
+// fingerprintSize is the number of bytes of the HMAC kept in a fingerprint
+const fingerprintSize = 16
+
+// SecretFingerprint describes the fingerprint of a secret in a text
+type SecretFingerprint struct {
+	// Offset is the byte offset of the envelope in the input
+	Offset int
+	// Envelope is the secret in its envelope
+	Envelope string
+	// Fingerprint is the fingerprint of the value of the secret
+	Fingerprint string
+}
+
+func (sh *secretHelperImpl) Fingerprint(encryptedTextPlusNonce string) (fingerprint string, err error) {
+	return sh.FingerprintContext(context.Background(), encryptedTextPlusNonce)
+}
+
+func (sh *secretHelperImpl) FingerprintEnvelope(encryptedTextInEnvelope string) (fingerprint string, err error) {
+
+	if sh.fingerprintKey == nil {
+		return "", ErrNoFingerprintKey
+	}
+	encryptedTextPlusNonce, ok := unwrapEnvelope(encryptedTextInEnvelope)
+	if !ok {
+		return "", secretErrorAt(newSecretError(ErrMalformedSecret, errors.New("the text is not a secret envelope")), encryptedTextInEnvelope, 0)
+	}
+
+	fingerprint, err = sh.FingerprintContext(context.Background(), encryptedTextPlusNonce)
+	if err != nil {
+		return "", secretErrorAt(err, encryptedTextInEnvelope, 0)
+	}
+
+	return fingerprint, nil
+}
+
+func (sh *secretHelperImpl) FingerprintContext(ctx context.Context, encryptedTextPlusNonce string) (fingerprint string, err error) {
+
+	if sh.fingerprintKey == nil {
+		return "", ErrNoFingerprintKey
+	}
+
+	// the fingerprint identifies the value wherever it's used, so the restrictions of the secret don't apply
+	decryptedText, _, err := sh.decrypt(ctx, AuditOperationInspect, encryptedTextPlusNonce, Scope{}, false)
+	if err != nil {
+		return "", err
+	}
+
+	return sh.fingerprint(decryptedText), nil
+}
+
+func (sh *secretHelperImpl) GetAllSecretFingerprints(input string) (fingerprints []SecretFingerprint, err error) {
+	return sh.GetAllSecretFingerprintsContext(context.Background(), input)
+}
+
+func (sh *secretHelperImpl) GetAllSecretFingerprintsContext(ctx context.Context, input string) (fingerprints []SecretFingerprint, err error) {
+
+	if sh.fingerprintKey == nil {
+		return nil, ErrNoFingerprintKey
+	}
+
+	// like GetExpiringSecrets the secrets that fail to decrypt are reported but don't stop the scan
+	var decryptErrs []error
+	for _, loc := range findAllEnvelopes(input) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		decryptedText, _, err := sh.decrypt(ctx, AuditOperationInspect, loc.secret(input), Scope{}, false)
+		if err != nil {
+			decryptErrs = append(decryptErrs, secretErrorAt(err, input[loc.start:loc.end], loc.start))
+			continue
+		}
+		fingerprints = append(fingerprints, SecretFingerprint{
+			Offset:      loc.start,
+			Envelope:    input[loc.start:loc.end],
+			Fingerprint: sh.fingerprint(decryptedText),
+		})
+	}
+
+	return fingerprints, errors.Join(decryptErrs...)
+}
+
+// fingerprint returns the hex encoded, truncated HMAC-SHA256 of the value under the fingerprint key, or an empty string
+// without a fingerprint key
+func (sh *secretHelperImpl) fingerprint(decryptedText string) string {
+
+	if sh.fingerprintKey == nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, sh.fingerprintKey)
+	mac.Write([]byte(decryptedText))
+
+	return hex.EncodeToString(mac.Sum(nil)[:fingerprintSize])
+}
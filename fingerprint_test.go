@@ -0,0 +1,168 @@
+package crypt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+
+	t.Run("ReturnsSameFingerprintForSameValueEncryptedTwice", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		first, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		second, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		firstFingerprint, err := secretHelper.Fingerprint(first)
+		assert.Nil(t, err)
+		secondFingerprint, err := secretHelper.Fingerprint(second)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 32, len(firstFingerprint))
+		assert.Equal(t, firstFingerprint, secondFingerprint)
+	})
+
+	t.Run("ReturnsSameFingerprintForValueEncryptedWithOtherKey", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		otherSecretHelper := NewSecretHelper("7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		first, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		second, err := otherSecretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		firstFingerprint, err := secretHelper.Fingerprint(first)
+		assert.Nil(t, err)
+		secondFingerprint, err := otherSecretHelper.Fingerprint(second)
+		assert.Nil(t, err)
+
+		assert.Equal(t, firstFingerprint, secondFingerprint)
+	})
+
+	t.Run("ReturnsOtherFingerprintForOtherValue", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		first, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		second, err := secretHelper.Encrypt("this is my other secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		firstFingerprint, err := secretHelper.Fingerprint(first)
+		assert.Nil(t, err)
+		secondFingerprint, err := secretHelper.Fingerprint(second)
+		assert.Nil(t, err)
+
+		assert.NotEqual(t, firstFingerprint, secondFingerprint)
+	})
+
+	t.Run("ReturnsOtherFingerprintForOtherFingerprintKey", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		otherSecretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("hX4vbs8nZPq3pE2mUw9RkLdTc6YyJfGa", false))
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		fingerprint, err := secretHelper.Fingerprint(encryptedTextPlusNonce)
+		assert.Nil(t, err)
+		otherFingerprint, err := otherSecretHelper.Fingerprint(encryptedTextPlusNonce)
+		assert.Nil(t, err)
+
+		assert.NotEqual(t, fingerprint, otherFingerprint)
+	})
+
+	t.Run("ReturnsFingerprintOfLegacySecretRestrictedToOtherPipeline", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+
+		// act
+		fingerprint, err := secretHelper.Fingerprint("n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=")
+
+		assert.Nil(t, err)
+		assert.NotEmpty(t, fingerprint)
+	})
+
+	t.Run("ReturnsErrNoFingerprintKeyWithoutFingerprintKey", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		// act
+		_, err := secretHelper.Fingerprint("MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P")
+
+		assert.True(t, errors.Is(err, ErrNoFingerprintKey))
+	})
+
+	t.Run("ReturnsErrMalformedSecretForMalformedSecret", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+
+		// act
+		_, err := secretHelper.Fingerprint("deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u")
+
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+	})
+
+	t.Run("ReturnsErrorForFingerprintKeyThatIsTooShort", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("short", false))
+
+		// act
+		_, err := secretHelper.Fingerprint("MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestFingerprintEnvelope(t *testing.T) {
+
+	t.Run("ReturnsFingerprintOfSecretInEnvelope", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		expectedFingerprint, err := secretHelper.Fingerprint("MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P")
+		assert.Nil(t, err)
+
+		// act
+		fingerprint, err := secretHelper.FingerprintEnvelope("ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)")
+
+		assert.Nil(t, err)
+		assert.Equal(t, expectedFingerprint, fingerprint)
+	})
+
+	t.Run("ReturnsErrMalformedSecretForTextWithoutEnvelope", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+
+		// act
+		_, err := secretHelper.FingerprintEnvelope("MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P")
+
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+	})
+}
+
+func TestGetAllSecretFingerprints(t *testing.T) {
+
+	t.Run("ReturnsFingerprintOfEverySecretAndErrorsForSecretsThatFail", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		input := "a: " + envelope + "\nb: ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u)\nc: " + envelope
+
+		// act
+		fingerprints, err := secretHelper.GetAllSecretFingerprints(input)
+
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+		if assert.Equal(t, 2, len(fingerprints)) {
+			assert.Equal(t, 3, fingerprints[0].Offset)
+			assert.Equal(t, envelope, fingerprints[0].Envelope)
+			assert.Equal(t, fingerprints[0].Fingerprint, fingerprints[1].Fingerprint)
+		}
+	})
+}
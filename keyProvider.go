@@ -0,0 +1,313 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotFound is returned by a KeyProvider if it doesn't have the requested key
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeyProvider supplies the keys a SecretHelper encrypts and decrypts with; implementations return key material as bytes
+// so it never has to be held as a string, and have to be safe for concurrent use since WithWorkers calls them from
+// several goroutines
+type KeyProvider interface {
+	// ActiveKey returns the key new secrets are encrypted with and its id
+	ActiveKey(ctx context.Context) (keyID string, key []byte, err error)
+	// Key returns the key with the given id, or an error wrapping ErrKeyNotFound
+	Key(ctx context.Context, keyID string) (key []byte, err error)
+	// Keys returns all keys with the active key first, to decrypt legacy secrets that don't identify their key
+	Keys(ctx context.Context) (keys [][]byte, err error)
+}
+
+// NewFileKeyProvider returns a KeyProvider that lazily reads a single key from a file and reads it again whenever the
+// file changes; the key id is derived from the key
+func NewFileKeyProvider(path string, base64encodedKey bool) KeyProvider {
+	return &fileKeyProvider{
+		path:             path,
+		base64encodedKey: base64encodedKey,
+	}
+}
+
+type fileKeyProvider struct {
+	path             string
+	base64encodedKey bool
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	keyID   string
+	key     []byte
+}
+
+func (p *fileKeyProvider) load() (keyID string, key []byte, err error) {
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.key != nil && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.keyID, p.key, nil
+	}
+
+	content, err := os.ReadFile(p.path)
+	if err != nil {
+		return
+	}
+	key, err = parseKeyBytes(content, p.base64encodedKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("key file %v: %w", p.path, err)
+	}
+
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+	p.keyID = deriveKeyID(key)
+	p.key = key
+
+	return p.keyID, p.key, nil
+}
+
+func (p *fileKeyProvider) ActiveKey(ctx context.Context) (keyID string, key []byte, err error) {
+	return p.load()
+}
+
+func (p *fileKeyProvider) Key(ctx context.Context, keyID string) (key []byte, err error) {
+
+	activeKeyID, key, err := p.load()
+	if err != nil {
+		return
+	}
+	if keyID != activeKeyID {
+		return nil, fmt.Errorf("key id %q: %w", keyID, ErrKeyNotFound)
+	}
+
+	return key, nil
+}
+
+func (p *fileKeyProvider) Keys(ctx context.Context) (keys [][]byte, err error) {
+
+	_, key, err := p.load()
+	if err != nil {
+		return
+	}
+
+	return [][]byte{key}, nil
+}
+
+// NewEnvKeyProvider returns a KeyProvider that reads a single key from an environment variable on every use, so it
+// picks up changes to the variable; the key id is derived from the key
+func NewEnvKeyProvider(name string, base64encodedKey bool) KeyProvider {
+	return &envKeyProvider{
+		name:             name,
+		base64encodedKey: base64encodedKey,
+	}
+}
+
+type envKeyProvider struct {
+	name             string
+	base64encodedKey bool
+}
+
+func (p *envKeyProvider) load() (keyID string, key []byte, err error) {
+
+	value, ok := os.LookupEnv(p.name)
+	if !ok || value == "" {
+		return "", nil, fmt.Errorf("environment variable %v is not set: %w", p.name, ErrKeyNotFound)
+	}
+	key, err = parseKeyBytes([]byte(value), p.base64encodedKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("environment variable %v: %w", p.name, err)
+	}
+
+	return deriveKeyID(key), key, nil
+}
+
+func (p *envKeyProvider) ActiveKey(ctx context.Context) (keyID string, key []byte, err error) {
+	return p.load()
+}
+
+func (p *envKeyProvider) Key(ctx context.Context, keyID string) (key []byte, err error) {
+
+	activeKeyID, key, err := p.load()
+	if err != nil {
+		return
+	}
+	if keyID != activeKeyID {
+		return nil, fmt.Errorf("key id %q: %w", keyID, ErrKeyNotFound)
+	}
+
+	return key, nil
+}
+
+func (p *envKeyProvider) Keys(ctx context.Context) (keys [][]byte, err error) {
+
+	_, key, err := p.load()
+	if err != nil {
+		return
+	}
+
+	return [][]byte{key}, nil
+}
+
+// NewLocalKMSKeyProvider returns a KeyProvider that stands in for a remote key management service during development
+// and in tests; it fetches keys from a directory holding a file named active with the id of the active key and a base64
+// encoded <keyid>.key file per key, caches them for the refresh interval and honours context cancellation like a remote
+// client would
+func NewLocalKMSKeyProvider(dir string, refreshInterval time.Duration) KeyProvider {
+	return &localKMSKeyProvider{
+		dir:             dir,
+		refreshInterval: refreshInterval,
+	}
+}
+
+type localKMSKeyProvider struct {
+	dir             string
+	refreshInterval time.Duration
+
+	mu          sync.Mutex
+	fetchedAt   time.Time
+	activeKeyID string
+	keyIDs      []string
+	keys        map[string][]byte
+}
+
+// fetch returns the cached keys, or fetches them again if the refresh interval has passed
+func (p *localKMSKeyProvider) fetch(ctx context.Context) (activeKeyID string, keyIDs []string, keys map[string][]byte, err error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && time.Since(p.fetchedAt) < p.refreshInterval {
+		return p.activeKeyID, p.keyIDs, p.keys, nil
+	}
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	active, err := os.ReadFile(filepath.Join(p.dir, "active"))
+	if err != nil {
+		return
+	}
+	activeKeyID = strings.TrimSpace(string(active))
+
+	paths, err := filepath.Glob(filepath.Join(p.dir, "*.key"))
+	if err != nil {
+		return
+	}
+	sort.Strings(paths)
+
+	keys = map[string][]byte{}
+	for _, path := range paths {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		keyID := strings.TrimSuffix(filepath.Base(path), ".key")
+		if !keyIDRegex.MatchString(keyID) {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		key, err := parseKeyBytes(content, true)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("key file %v: %w", path, err)
+		}
+		keys[keyID] = key
+		keyIDs = append(keyIDs, keyID)
+	}
+	if _, ok := keys[activeKeyID]; !ok {
+		return "", nil, nil, fmt.Errorf("active key id %q: %w", activeKeyID, ErrKeyNotFound)
+	}
+
+	p.fetchedAt = time.Now()
+	p.activeKeyID = activeKeyID
+	p.keyIDs = keyIDs
+	p.keys = keys
+
+	return activeKeyID, keyIDs, keys, nil
+}
+
+func (p *localKMSKeyProvider) ActiveKey(ctx context.Context) (keyID string, key []byte, err error) {
+
+	activeKeyID, _, keys, err := p.fetch(ctx)
+	if err != nil {
+		return
+	}
+
+	return activeKeyID, keys[activeKeyID], nil
+}
+
+func (p *localKMSKeyProvider) Key(ctx context.Context, keyID string) (key []byte, err error) {
+
+	_, _, keys, err := p.fetch(ctx)
+	if err != nil {
+		return
+	}
+	key, ok := keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key id %q: %w", keyID, ErrKeyNotFound)
+	}
+
+	return key, nil
+}
+
+func (p *localKMSKeyProvider) Keys(ctx context.Context) (keys [][]byte, err error) {
+
+	activeKeyID, keyIDs, keysByID, err := p.fetch(ctx)
+	if err != nil {
+		return
+	}
+
+	keys = append(keys, keysByID[activeKeyID])
+	for _, keyID := range keyIDs {
+		if keyID != activeKeyID {
+			keys = append(keys, keysByID[keyID])
+		}
+	}
+
+	return keys, nil
+}
+
+// parseKeyBytes decodes and validates key material without converting it to a string; a single trailing newline is
+// ignored
+func parseKeyBytes(content []byte, base64encodedKey bool) (key []byte, err error) {
+
+	content = bytes.TrimSuffix(content, []byte("\n"))
+	content = bytes.TrimSuffix(content, []byte("\r"))
+
+	key = content
+	if base64encodedKey {
+		key = make([]byte, base64.StdEncoding.DecodedLen(len(content)))
+		n, err := base64.StdEncoding.Decode(key, content)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		key = key[:n]
+	} else {
+		key = append([]byte{}, content...)
+	}
+
+	// the key should be the AES key, either 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,215 @@
+package crypt
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileKeyProvider(t *testing.T) {
+
+	t.Run("ReturnsKeyFromFileWithDerivedKeyID", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "key")
+		err := os.WriteFile(path, []byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp\n"), 0600)
+		assert.Nil(t, err)
+		keyProvider := NewFileKeyProvider(path, false)
+
+		// act
+		keyID, key, err := keyProvider.ActiveKey(context.Background())
+
+		assert.Nil(t, err)
+		assert.Equal(t, "oTlW9djx", keyID)
+		assert.Equal(t, []byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp"), key)
+	})
+
+	t.Run("ReturnsNewKeyAfterFileChanges", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "key")
+		err := os.WriteFile(path, []byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp"), 0600)
+		assert.Nil(t, err)
+		keyProvider := NewFileKeyProvider(path, false)
+		_, _, err = keyProvider.ActiveKey(context.Background())
+		assert.Nil(t, err)
+		err = os.WriteFile(path, []byte("AmHn8kQ0vH3z/s6MPzgrCRe3qDtP2W4CkPCbzSB8W9E="), 0600)
+		assert.Nil(t, err)
+		keyProvider.(*fileKeyProvider).base64encodedKey = true
+
+		// act
+		_, key, err := keyProvider.ActiveKey(context.Background())
+
+		assert.Nil(t, err)
+		assert.Equal(t, 32, len(key))
+		assert.NotEqual(t, []byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp"), key)
+	})
+
+	t.Run("ReturnsErrKeyNotFoundForOtherKeyID", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "key")
+		err := os.WriteFile(path, []byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp"), 0600)
+		assert.Nil(t, err)
+		keyProvider := NewFileKeyProvider(path, false)
+
+		// act
+		_, err = keyProvider.Key(context.Background(), "other")
+
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+	})
+
+	t.Run("ReturnsErrInvalidKeyIfKeyHasInvalidLength", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "key")
+		err := os.WriteFile(path, []byte("tooshort"), 0600)
+		assert.Nil(t, err)
+		keyProvider := NewFileKeyProvider(path, false)
+
+		// act
+		_, _, err = keyProvider.ActiveKey(context.Background())
+
+		assert.True(t, errors.Is(err, ErrInvalidKey))
+	})
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+
+	t.Run("ReturnsKeyFromEnvironmentVariable", func(t *testing.T) {
+
+		t.Setenv("ZIPLINEE_TEST_KEY", "AmHn8kQ0vH3z/s6MPzgrCRe3qDtP2W4CkPCbzSB8W9E=")
+		keyProvider := NewEnvKeyProvider("ZIPLINEE_TEST_KEY", true)
+
+		// act
+		keyID, key, err := keyProvider.ActiveKey(context.Background())
+
+		assert.Nil(t, err)
+		assert.Equal(t, 32, len(key))
+		assert.Equal(t, deriveKeyID(key), keyID)
+	})
+
+	t.Run("ReturnsErrKeyNotFoundIfEnvironmentVariableIsNotSet", func(t *testing.T) {
+
+		keyProvider := NewEnvKeyProvider("ZIPLINEE_TEST_KEY_NOT_SET", false)
+
+		// act
+		_, _, err := keyProvider.ActiveKey(context.Background())
+
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+	})
+}
+
+func TestLocalKMSKeyProvider(t *testing.T) {
+
+	writeKeys := func(t *testing.T, dir, activeKeyID string, keys map[string]string) {
+		err := os.WriteFile(filepath.Join(dir, "active"), []byte(activeKeyID+"\n"), 0600)
+		assert.Nil(t, err)
+		for keyID, key := range keys {
+			err := os.WriteFile(filepath.Join(dir, keyID+".key"), []byte(key+"\n"), 0600)
+			assert.Nil(t, err)
+		}
+	}
+
+	t.Run("ReturnsActiveAndRetiredKeys", func(t *testing.T) {
+
+		dir := t.TempDir()
+		writeKeys(t, dir, "2024-02", map[string]string{
+			"2024-01": "U2F6YndNZjNOWnhWVmJCcVFIZWJQY1hDcXJWbjNERHA=",
+			"2024-02": "AmHn8kQ0vH3z/s6MPzgrCRe3qDtP2W4CkPCbzSB8W9E=",
+		})
+		keyProvider := NewLocalKMSKeyProvider(dir, time.Minute)
+
+		// act
+		keyID, _, err := keyProvider.ActiveKey(context.Background())
+		assert.Nil(t, err)
+		retiredKey, err := keyProvider.Key(context.Background(), "2024-01")
+		assert.Nil(t, err)
+		keys, err := keyProvider.Keys(context.Background())
+		assert.Nil(t, err)
+
+		assert.Equal(t, "2024-02", keyID)
+		assert.Equal(t, []byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp"), retiredKey)
+		if assert.Equal(t, 2, len(keys)) {
+			assert.Equal(t, []byte("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp"), keys[1])
+		}
+	})
+
+	t.Run("ReturnsCachedKeysWithinRefreshInterval", func(t *testing.T) {
+
+		dir := t.TempDir()
+		writeKeys(t, dir, "2024-01", map[string]string{
+			"2024-01": "U2F6YndNZjNOWnhWVmJCcVFIZWJQY1hDcXJWbjNERHA=",
+		})
+		keyProvider := NewLocalKMSKeyProvider(dir, time.Hour)
+		_, _, err := keyProvider.ActiveKey(context.Background())
+		assert.Nil(t, err)
+		writeKeys(t, dir, "2024-02", map[string]string{
+			"2024-02": "AmHn8kQ0vH3z/s6MPzgrCRe3qDtP2W4CkPCbzSB8W9E=",
+		})
+
+		// act
+		keyID, _, err := keyProvider.ActiveKey(context.Background())
+
+		assert.Nil(t, err)
+		assert.Equal(t, "2024-01", keyID)
+	})
+
+	t.Run("ReturnsErrorIfContextIsCancelled", func(t *testing.T) {
+
+		dir := t.TempDir()
+		writeKeys(t, dir, "2024-01", map[string]string{
+			"2024-01": "U2F6YndNZjNOWnhWVmJCcVFIZWJQY1hDcXJWbjNERHA=",
+		})
+		keyProvider := NewLocalKMSKeyProvider(dir, time.Hour)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// act
+		_, _, err := keyProvider.ActiveKey(ctx)
+
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+
+	t.Run("ReturnsErrKeyNotFoundIfActiveKeyIsMissing", func(t *testing.T) {
+
+		dir := t.TempDir()
+		writeKeys(t, dir, "2024-02", map[string]string{
+			"2024-01": "U2F6YndNZjNOWnhWVmJCcVFIZWJQY1hDcXJWbjNERHA=",
+		})
+		keyProvider := NewLocalKMSKeyProvider(dir, time.Hour)
+
+		// act
+		_, _, err := keyProvider.ActiveKey(context.Background())
+
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+	})
+}
+
+func TestNewSecretHelperWithKeyProvider(t *testing.T) {
+
+	t.Run("DecryptsSecretsWithKeyFromProvider", func(t *testing.T) {
+
+		t.Setenv("ZIPLINEE_TEST_KEY", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp")
+		secretHelper := NewSecretHelperWithKeyProvider(NewEnvKeyProvider("ZIPLINEE_TEST_KEY", false))
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		decryptedText, _, err := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false).Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+	})
+
+	t.Run("ReturnsErrKeyUnavailableIfProviderFails", func(t *testing.T) {
+
+		secretHelper := NewSecretHelperWithKeyProvider(NewFileKeyProvider(filepath.Join(t.TempDir(), "missing"), false))
+
+		// act
+		_, _, err := secretHelper.Decrypt("MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P", "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrKeyUnavailable))
+	})
+}
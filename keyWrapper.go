@@ -0,0 +1,75 @@
+package crypt
+
+import (
+	"context"
+	"fmt"
+)
+
+// KeyWrapper wraps the random data keys secrets are encrypted with under a master key, like the encrypt and decrypt
+// calls of a key management service; rotating the master key then only requires rewrapping the data keys; like a
+// KeyProvider it has to be safe for concurrent use
+type KeyWrapper interface {
+	// WrapKey wraps the data key with the active master key and returns the id of that master key
+	WrapKey(ctx context.Context, dataKey []byte) (masterKeyID string, wrappedKey []byte, err error)
+	// UnwrapKey unwraps a data key wrapped with the master key with the given id; it returns an error wrapping
+	// ErrKeyNotFound if it doesn't have that master key and one wrapping ErrTamperedSecret if the wrapped key doesn't
+	// authenticate
+	UnwrapKey(ctx context.Context, masterKeyID string, wrappedKey []byte) (dataKey []byte, err error)
+}
+
+// NewKeyProviderKeyWrapper returns a KeyWrapper that wraps data keys with AES-GCM under the active key of the key
+// provider and unwraps them with any of its keys
+func NewKeyProviderKeyWrapper(keyProvider KeyProvider) KeyWrapper {
+	return &keyProviderKeyWrapper{
+		keyProvider: keyProvider,
+	}
+}
+
+type keyProviderKeyWrapper struct {
+	keyProvider KeyProvider
+	aeads       aeadCache
+}
+
+func (w *keyProviderKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) (masterKeyID string, wrappedKey []byte, err error) {
+
+	masterKeyID, masterKey, err := w.keyProvider.ActiveKey(ctx)
+	if err != nil {
+		return
+	}
+
+	aesgcm, err := w.aeads.get(masterKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	// the wrapped key is the nonce followed by the sealed data key
+	nonce, ciphertext, err := seal(aesgcm, dataKey, nil)
+	if err != nil {
+		return
+	}
+
+	return masterKeyID, append(nonce, ciphertext...), nil
+}
+
+func (w *keyProviderKeyWrapper) UnwrapKey(ctx context.Context, masterKeyID string, wrappedKey []byte) (dataKey []byte, err error) {
+
+	masterKey, err := w.keyProvider.Key(ctx, masterKeyID)
+	if err != nil {
+		return
+	}
+
+	aesgcm, err := w.aeads.get(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	if len(wrappedKey) < aesgcm.NonceSize() {
+		return nil, fmt.Errorf("%w: the wrapped key is too short", ErrTamperedSecret)
+	}
+	dataKey, err = aesgcm.Open(nil, wrappedKey[:aesgcm.NonceSize()], wrappedKey[aesgcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTamperedSecret, err)
+	}
+
+	return dataKey, nil
+}
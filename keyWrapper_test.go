@@ -0,0 +1,216 @@
+package crypt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyProviderKeyWrapper(t *testing.T) {
+
+	t.Run("ReturnsUnwrappedDataKey", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		keyWrapper := NewKeyProviderKeyWrapper(keyring)
+		masterKeyID, wrappedKey, err := keyWrapper.WrapKey(context.Background(), []byte("7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot"))
+		assert.Nil(t, err)
+
+		// act
+		dataKey, err := keyWrapper.UnwrapKey(context.Background(), masterKeyID, wrappedKey)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "master-1", masterKeyID)
+		assert.Equal(t, []byte("7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot"), dataKey)
+	})
+
+	t.Run("ReturnsErrTamperedSecretIfWrappedKeyIsModified", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		keyWrapper := NewKeyProviderKeyWrapper(keyring)
+		masterKeyID, wrappedKey, err := keyWrapper.WrapKey(context.Background(), []byte("7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot"))
+		assert.Nil(t, err)
+		wrappedKey[len(wrappedKey)-1] ^= 1
+
+		// act
+		_, err = keyWrapper.UnwrapKey(context.Background(), masterKeyID, wrappedKey)
+
+		assert.True(t, errors.Is(err, ErrTamperedSecret))
+	})
+}
+
+func TestNewSecretHelperWithKeyWrapper(t *testing.T) {
+
+	t.Run("ReturnsSecretWithWrappedDataKey", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		secretHelper := NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(keyring))
+
+		// act
+		first, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		second, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		splittedFirst := strings.Split(first, ".")
+		splittedSecond := strings.Split(second, ".")
+		assert.Equal(t, 7, len(splittedFirst))
+		assert.Equal(t, "v2w", splittedFirst[0])
+		assert.Equal(t, "master-1", splittedFirst[1])
+		assert.NotEqual(t, splittedFirst[2], splittedSecond[2])
+	})
+
+	t.Run("ReturnsDecryptedSecret", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		secretHelper := NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(keyring))
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		// act
+		decryptedText, pipelineAllowList, err := secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", pipelineAllowList)
+	})
+
+	t.Run("ReturnsDecryptedSecretForSecretHelperWithKeyringHoldingMasterKey", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		encryptedTextPlusNonce, err := NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(keyring)).Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		decryptedText, _, err := NewSecretHelperWithKeyring(keyring).Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+	})
+
+	t.Run("ReturnsErrUnknownKeyIfMasterKeyIsMissing", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		encryptedTextPlusNonce, err := NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(keyring)).Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		otherKeyring := NewKeyring()
+		_, err = otherKeyring.AddKey("master-2", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(otherKeyring)).Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrUnknownKey))
+	})
+
+	t.Run("ReturnsErrTamperedSecretIfWrappedKeyIsSwapped", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		secretHelper := NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(keyring))
+		first, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		second, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		splittedFirst := strings.Split(first, ".")
+		splittedFirst[2] = strings.Split(second, ".")[2]
+
+		// act
+		_, _, err = secretHelper.Decrypt(strings.Join(splittedFirst, "."), "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrTamperedSecret))
+	})
+
+	t.Run("ReturnsErrUnknownKeyForSecretWithoutDataKey", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		encryptedTextPlusNonce, err := NewSecretHelperWithKeyring(keyring).Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(keyring)).Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrUnknownKey))
+	})
+}
+
+func TestRewrapAllEnvelopes(t *testing.T) {
+
+	t.Run("ReturnsEnvelopesWithDataKeysWrappedByActiveMasterKey", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		secretHelper := NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(keyring))
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		_, err = keyring.AddKey("master-2", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+		assert.Nil(t, err)
+		err = keyring.SetActiveKey("master-2")
+		assert.Nil(t, err)
+
+		// act
+		rewrappedText, err := secretHelper.RewrapAllEnvelopes("key: " + envelope)
+
+		assert.Nil(t, err)
+		splittedEnvelope := strings.Split(strings.TrimSuffix(envelope, ")"), ".")
+		splittedRewrapped := strings.Split(strings.TrimSuffix(rewrappedText, ")"), ".")
+		assert.Equal(t, "master-2", splittedRewrapped[2])
+		assert.Equal(t, splittedEnvelope[4:], splittedRewrapped[4:])
+
+		newKeyring := NewKeyring()
+		_, err = newKeyring.AddKey("master-2", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+		assert.Nil(t, err)
+		decryptedText, err := NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(newKeyring)).DecryptAllEnvelopes(rewrappedText, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		assert.Equal(t, "key: this is my secret", decryptedText)
+	})
+
+	t.Run("LeavesEnvelopesWithoutDataKeyUntouched", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		rewrappedText, err := secretHelper.RewrapAllEnvelopes("key: " + envelope)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "key: "+envelope, rewrappedText)
+	})
+
+	t.Run("KeepsEnvelopesThatFailToRewrapAndReturnsError", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("master-1", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		envelope, err := NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(keyring)).EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		otherKeyring := NewKeyring()
+		_, err = otherKeyring.AddKey("master-2", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+		assert.Nil(t, err)
+
+		// act
+		rewrappedText, err := NewSecretHelperWithKeyWrapper(NewKeyProviderKeyWrapper(otherKeyring)).RewrapAllEnvelopes("key: " + envelope)
+
+		assert.True(t, errors.Is(err, ErrUnknownKey))
+		assert.Equal(t, "key: "+envelope, rewrappedText)
+	})
+}
@@ -0,0 +1,172 @@
+package crypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// keyIDRegex is the regular expression a key id has to match to be embeddable in a secret
+var keyIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Keyring holds the active key new secrets are encrypted with and the retired keys that are still valid for decryption;
+// it's the in-memory KeyProvider
+type Keyring struct {
+	mu          sync.RWMutex
+	activeKeyID string
+	keyIDs      []string
+	keys        map[string][]byte
+}
+
+// NewKeyring returns a new empty Keyring
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: map[string][]byte{},
+	}
+}
+
+// KeyID returns the key id derived from the key, which is used when a key is added without an explicit id
+func KeyID(key string, base64encodedKey bool) (keyID string, err error) {
+
+	keyBytes, err := decodeKey(key, base64encodedKey)
+	if err != nil {
+		return
+	}
+
+	return deriveKeyID(keyBytes), nil
+}
+
+// AddKey adds a key to the keyring; if keyID is empty it's derived from the key, the first key added becomes the active key
+func (kr *Keyring) AddKey(keyID, key string, base64encodedKey bool) (string, error) {
+
+	keyBytes, err := decodeKey(key, base64encodedKey)
+	if err != nil {
+		return "", err
+	}
+
+	// the key should be the AES key, either 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256
+	if _, err := aes.NewCipher(keyBytes); err != nil {
+		return "", err
+	}
+
+	if keyID == "" {
+		keyID = deriveKeyID(keyBytes)
+	}
+	if !keyIDRegex.MatchString(keyID) {
+		return "", fmt.Errorf("key id %q contains characters other than letters, digits, '-' and '_'", keyID)
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, ok := kr.keys[keyID]; ok {
+		return "", fmt.Errorf("key id %q is already in use", keyID)
+	}
+
+	kr.keys[keyID] = keyBytes
+	kr.keyIDs = append(kr.keyIDs, keyID)
+	if kr.activeKeyID == "" {
+		kr.activeKeyID = keyID
+	}
+
+	return keyID, nil
+}
+
+// SetActiveKey sets the key new secrets are encrypted with
+func (kr *Keyring) SetActiveKey(keyID string) error {
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, ok := kr.keys[keyID]; !ok {
+		return fmt.Errorf("key id %q is not in the keyring", keyID)
+	}
+	kr.activeKeyID = keyID
+
+	return nil
+}
+
+// ActiveKeyID returns the id of the key new secrets are encrypted with
+func (kr *Keyring) ActiveKeyID() string {
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.activeKeyID
+}
+
+// KeyIDs returns the ids of all keys in the keyring in the order they were added
+func (kr *Keyring) KeyIDs() []string {
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return append([]string{}, kr.keyIDs...)
+}
+
+// ActiveKey returns the key new secrets are encrypted with
+func (kr *Keyring) ActiveKey(ctx context.Context) (keyID string, keyBytes []byte, err error) {
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if kr.activeKeyID == "" {
+		return "", nil, fmt.Errorf("the keyring has no keys: %w", ErrKeyNotFound)
+	}
+
+	return kr.activeKeyID, kr.keys[kr.activeKeyID], nil
+}
+
+// Key returns the key with the given id
+func (kr *Keyring) Key(ctx context.Context, keyID string) (keyBytes []byte, err error) {
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	keyBytes, ok := kr.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key id %q: %w", keyID, ErrKeyNotFound)
+	}
+
+	return keyBytes, nil
+}
+
+// Keys returns all keys with the active key first
+func (kr *Keyring) Keys(ctx context.Context) (keys [][]byte, err error) {
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if kr.activeKeyID != "" {
+		keys = append(keys, kr.keys[kr.activeKeyID])
+	}
+	for _, keyID := range kr.keyIDs {
+		if keyID != kr.activeKeyID {
+			keys = append(keys, kr.keys[keyID])
+		}
+	}
+
+	return keys, nil
+}
+
+func decodeKey(key string, base64encodedKey bool) (keyBytes []byte, err error) {
+
+	keyBytes = []byte(key)
+	if base64encodedKey {
+		keyBytes, err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return keyBytes, err
+		}
+	}
+
+	return keyBytes, nil
+}
+
+func deriveKeyID(keyBytes []byte) string {
+	sum := sha256.Sum256(keyBytes)
+	return base64.RawURLEncoding.EncodeToString(sum[:6])
+}
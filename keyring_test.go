@@ -0,0 +1,188 @@
+package crypt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyringAddKey(t *testing.T) {
+
+	t.Run("ReturnsDerivedKeyIDIfKeyIDIsEmpty", func(t *testing.T) {
+
+		keyring := NewKeyring()
+
+		// act
+		keyID, err := keyring.AddKey("", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "oTlW9djx", keyID)
+	})
+
+	t.Run("ReturnsExplicitKeyID", func(t *testing.T) {
+
+		keyring := NewKeyring()
+
+		// act
+		keyID, err := keyring.AddKey("2024-01", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "2024-01", keyID)
+	})
+
+	t.Run("MakesFirstKeyTheActiveKey", func(t *testing.T) {
+
+		keyring := NewKeyring()
+
+		// act
+		_, err := keyring.AddKey("first", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		_, err = keyring.AddKey("second", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "first", keyring.ActiveKeyID())
+		assert.Equal(t, []string{"first", "second"}, keyring.KeyIDs())
+	})
+
+	t.Run("ReturnsErrorIfKeyIDContainsDot", func(t *testing.T) {
+
+		keyring := NewKeyring()
+
+		// act
+		_, err := keyring.AddKey("2024.01", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfKeyIDIsAlreadyInUse", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("first", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+
+		// act
+		_, err = keyring.AddKey("first", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfKeyHasInvalidLength", func(t *testing.T) {
+
+		keyring := NewKeyring()
+
+		// act
+		_, err := keyring.AddKey("", "tooshort", false)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfKeyIsNotBase64Encoded", func(t *testing.T) {
+
+		keyring := NewKeyring()
+
+		// act
+		_, err := keyring.AddKey("", "not base64 encoded", true)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestKeyringSetActiveKey(t *testing.T) {
+
+	t.Run("ReturnsErrorIfKeyIDIsNotInKeyring", func(t *testing.T) {
+
+		keyring := NewKeyring()
+
+		// act
+		err := keyring.SetActiveKey("unknown")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestNewSecretHelperWithKeyring(t *testing.T) {
+
+	t.Run("EncryptsWithActiveKey", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("old", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		_, err = keyring.AddKey("new", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+		assert.Nil(t, err)
+		err = keyring.SetActiveKey("new")
+		assert.Nil(t, err)
+		secretHelper := NewSecretHelperWithKeyring(keyring)
+
+		// act
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(encryptedTextPlusNonce, "v2.new."))
+	})
+
+	t.Run("DecryptsSecretsEncryptedWithRetiredKey", func(t *testing.T) {
+
+		encryptedTextPlusNonce, err := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false).Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		keyring := NewKeyring()
+		_, err = keyring.AddKey("", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+		assert.Nil(t, err)
+		_, err = keyring.AddKey("", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		secretHelper := NewSecretHelperWithKeyring(keyring)
+
+		// act
+		decryptedText, pipelineAllowList, err := secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", pipelineAllowList)
+	})
+
+	t.Run("DecryptsLegacySecretsEncryptedWithRetiredKey", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+		assert.Nil(t, err)
+		_, err = keyring.AddKey("", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		secretHelper := NewSecretHelperWithKeyring(keyring)
+		input := `
+		ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)
+
+		ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=)
+		`
+
+		// act
+		values, err := secretHelper.GetAllSecretValues(input, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"this is my secret", "this is my secret"}, values)
+	})
+
+	t.Run("ReturnsErrorIfKeyIDIsNotInKeyring", func(t *testing.T) {
+
+		encryptedTextPlusNonce, err := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false).Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		keyring := NewKeyring()
+		_, err = keyring.AddKey("", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+		assert.Nil(t, err)
+		secretHelper := NewSecretHelperWithKeyring(keyring)
+
+		// act
+		_, _, err = secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfKeyringHasNoKeys", func(t *testing.T) {
+
+		secretHelper := NewSecretHelperWithKeyring(NewKeyring())
+
+		// act
+		_, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,711 @@
+package crypt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrInvalidManifest is returned when a manifest isn't valid YAML
+	ErrInvalidManifest = errors.New("the manifest is invalid")
+
+	// ErrManifestPathNotFound is returned when a path doesn't lead to a value in the manifest
+	ErrManifestPathNotFound = errors.New("the path doesn't lead to a value in the manifest")
+
+	// ErrUnclosedPlaintextMarker is returned when a plaintext marker in a value of a manifest has no closing parenthesis
+	ErrUnclosedPlaintextMarker = errors.New("the plaintext marker has no closing parenthesis")
+
+	// ErrManifestValueEmpty is returned when a path to encrypt leads to a null or empty value, which isn't a secret
+	ErrManifestValueEmpty = errors.New("the value at the path is null or empty")
+
+	// ErrPlaintextMarkerOutsideValue is returned when a plaintext marker is in a comment or a key of a manifest, where
+	// it isn't replaced
+	ErrPlaintextMarkerOutsideValue = errors.New("the plaintext marker isn't in a value")
+)
+
+// plaintextMarkerPrefix starts a marker around a plaintext value in a manifest, like ziplinee.plaintext(my secret),
+// which is replaced with the envelope of the value when the manifest is encrypted
+const plaintextMarkerPrefix = "ziplinee.plaintext("
+
+// ManifestSecret is an envelope in a value of a YAML manifest
+type ManifestSecret struct {
+	// Path is the path of the value holding the envelope, like stages.deploy.env.TOKEN or stages.deploy.commands[0]; keys
+	// with dots or brackets are quoted, like labels["app.kubernetes.io/name"]
+	Path string
+	// Offset is the byte offset of the envelope in the manifest
+	Offset int
+	// Line is the line of the envelope in the manifest, starting at 1
+	Line int
+	// Column is the column of the envelope in the manifest, starting at 1
+	Column int
+	// Envelope is the secret in its envelope
+	Envelope string
+}
+
+// GetManifestSecrets returns the envelopes in the values of a YAML manifest with their paths; unlike GetAllSecretEnvelopes
+// it skips envelopes in comments and keys
+func GetManifestSecrets(manifest string) (secrets []ManifestSecret, err error) {
+
+	source, scalars, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scalar := range scalars {
+		if len(findAllEnvelopes(scalar.value)) == 0 {
+			continue
+		}
+		raw, err := source.raw(scalar)
+		if err != nil {
+			return nil, err
+		}
+		for _, loc := range findAllEnvelopes(raw) {
+			offset := scalar.start + loc.start
+			line, column := source.position(offset)
+			secrets = append(secrets, ManifestSecret{
+				Path:     scalar.path,
+				Offset:   offset,
+				Line:     line,
+				Column:   column,
+				Envelope: raw[loc.start:loc.end],
+			})
+		}
+	}
+
+	return secrets, nil
+}
+
+// DecryptManifest decrypts the envelopes in the values at the paths of a YAML manifest for the scope, or in all values if
+// paths is empty; comments, ordering and indentation are preserved, only values that can't hold their decrypted text in
+// their own style are rewritten as double-quoted strings
+func DecryptManifest(ctx context.Context, secretHelper SecretHelper, manifest string, scope Scope, paths []string) (decryptedManifest string, err error) {
+
+	source, scalars, err := parseManifest(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	edits, err := source.replaceInValues(scalars, paths, findAllEnvelopes, func(envelope string, offset int) (string, error) {
+		decryptedText, _, err := secretHelper.DecryptEnvelopeContext(ctx, envelope, scope)
+		if err != nil {
+			return "", secretErrorAt(err, envelope, offset)
+		}
+		return decryptedText, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return source.rewrite(scalars, edits)
+}
+
+// ReencryptManifest re-encrypts the envelopes in the values at the paths of a YAML manifest with the target, or in all
+// values if paths is empty, like ReencryptAllEnvelopes with WithTargetSecretHelper; a nil target re-encrypts with the
+// active key of the secret helper itself
+func ReencryptManifest(ctx context.Context, secretHelper, target SecretHelper, manifest, pipeline string, paths []string) (reencryptedManifest string, err error) {
+
+	if target == nil {
+		target = secretHelper
+	}
+	source, scalars, err := parseManifest(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	edits, err := source.replaceInValues(scalars, paths, findAllEnvelopes, func(envelope string, offset int) (string, error) {
+		reencryptedEnvelope, _, err := secretHelper.ReencryptAllEnvelopesContext(ctx, envelope, pipeline, false, WithTargetSecretHelper(target))
+		if err != nil {
+			return "", secretErrorAt(err, envelope, offset)
+		}
+		return reencryptedEnvelope, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return source.rewrite(scalars, edits)
+}
+
+// EncryptManifest replaces the values at the paths of a YAML or JSON manifest with envelopes of those values, and every
+// ziplinee.plaintext(...) marker in a value with the envelope of the text inside it; a marker ends at the parenthesis
+// that closes it, so the text can only hold balanced parentheses. A marker that's never closed fails with
+// ErrUnclosedPlaintextMarker, and one in a comment or a key with ErrPlaintextMarkerOutsideValue. A null or empty
+// value at a path fails with ErrManifestValueEmpty. Values that already are an envelope are left as they are, and values
+// at the paths that hold markers only have their markers replaced
+func EncryptManifest(ctx context.Context, secretHelper SecretHelper, manifest, pipelineAllowList string, paths []string, options ...EncryptOption) (encryptedManifest string, err error) {
+
+	source, scalars, err := parseManifest(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	// an unclosed marker would leave its plaintext in the manifest, so it fails the whole manifest
+	found := map[string]bool{}
+	for _, scalar := range scalars {
+		found[scalar.path] = true
+		if hasUnclosedPlaintextMarker(scalar.value) {
+			return "", fmt.Errorf("%w: %v", ErrUnclosedPlaintextMarker, scalar.path)
+		}
+	}
+	for _, path := range paths {
+		if !found[path] {
+			return "", fmt.Errorf("%w: %v", ErrManifestPathNotFound, path)
+		}
+	}
+
+	// a null encrypted as text would turn into a secret, so neither nulls nor empty values are encrypted at a path
+	selected := map[string]bool{}
+	for _, path := range paths {
+		selected[path] = true
+	}
+	for _, scalar := range scalars {
+		if selected[scalar.path] && (scalar.null || scalar.value == "") {
+			return "", fmt.Errorf("%w: %v", ErrManifestValueEmpty, scalar.path)
+		}
+	}
+
+	edits, err := source.replaceInValues(scalars, nil, findPlaintextMarkers, func(marker string, offset int) (string, error) {
+		return secretHelper.EncryptEnvelopeContext(ctx, marker[len(plaintextMarkerPrefix):len(marker)-1], pipelineAllowList, options...)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// like an unclosed marker, a marker in a comment or a key would leave its plaintext in the manifest
+	if offset, ok := source.findPlaintextMarkerOutsideValues(scalars); ok {
+		line, column := source.position(offset)
+		return "", fmt.Errorf("%w: at line %v, column %v", ErrPlaintextMarkerOutsideValue, line, column)
+	}
+
+	for _, path := range paths {
+		for i, scalar := range scalars {
+			if scalar.path != path {
+				continue
+			}
+			if _, ok := unwrapEnvelope(scalar.value); ok {
+				continue
+			}
+			if _, ok := edits[i]; ok {
+				continue
+			}
+			raw, err := source.raw(scalar)
+			if err != nil {
+				return "", err
+			}
+			envelope, err := secretHelper.EncryptEnvelopeContext(ctx, scalar.value, pipelineAllowList, options...)
+			if err != nil {
+				return "", fmt.Errorf("%v: %w", path, err)
+			}
+			edit := manifestEdit{
+				raw:   envelope,
+				value: envelope,
+			}
+			// quoted values stay quoted, and plain values in a flow collection like a JSON number or bool get quoted, so the
+			// envelope in a JSON document is a string
+			switch {
+			case scalar.style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0:
+				edit.raw = raw[:1] + envelope + raw[:1]
+			case scalar.flow:
+				edit.raw = quoteManifestValue(envelope)
+			}
+			edits[i] = edit
+		}
+	}
+
+	return source.rewrite(scalars, edits)
+}
+
+// replaceInValues returns the edits that replace what find locates in the values at the paths, or in all values if paths
+// is empty; replace receives what's located as it is in the value, and its offset in the manifest
+func (s *manifestSource) replaceInValues(scalars []manifestScalar, paths []string, find func(string) []envelopeLocation, replace func(text string, offset int) (string, error)) (map[int]manifestEdit, error) {
+
+	selected := map[string]bool{}
+	for _, path := range paths {
+		selected[path] = true
+	}
+
+	edits := map[int]manifestEdit{}
+	for i, scalar := range scalars {
+		if len(paths) > 0 && !selected[scalar.path] {
+			continue
+		}
+		valueLocations := find(scalar.value)
+		if len(valueLocations) == 0 {
+			continue
+		}
+		raw, err := s.raw(scalar)
+		if err != nil {
+			return nil, err
+		}
+		rawLocations := find(raw)
+		if len(rawLocations) != len(valueLocations) {
+			return nil, fmt.Errorf("%w: the value at %v has escapes that can't be told apart from its source", ErrInvalidManifest, scalar.path)
+		}
+
+		// what's located is in the same order in the source and the value, only the escapes in and around it can differ
+		var rawBuilder, valueBuilder strings.Builder
+		rawEnd, valueEnd, inPlace := 0, 0, true
+		for j, loc := range rawLocations {
+			replacement, err := replace(scalar.value[valueLocations[j].start:valueLocations[j].end], scalar.start+loc.start)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", scalar.path, err)
+			}
+			encoded, ok := scalar.encode(replacement)
+			inPlace = inPlace && ok
+			rawBuilder.WriteString(raw[rawEnd:loc.start])
+			rawBuilder.WriteString(encoded)
+			rawEnd = loc.end
+			valueBuilder.WriteString(scalar.value[valueEnd:valueLocations[j].start])
+			valueBuilder.WriteString(replacement)
+			valueEnd = valueLocations[j].end
+		}
+		rawBuilder.WriteString(raw[rawEnd:])
+		valueBuilder.WriteString(scalar.value[valueEnd:])
+
+		edit := manifestEdit{
+			value: valueBuilder.String(),
+		}
+		if inPlace {
+			edit.raw = rawBuilder.String()
+		}
+		edits[i] = edit
+	}
+
+	return edits, nil
+}
+
+// findPlaintextMarkers returns the locations of the plaintext markers in s
+func findPlaintextMarkers(s string) (locations []envelopeLocation) {
+
+	for offset := 0; ; {
+		i := strings.Index(s[offset:], plaintextMarkerPrefix)
+		if i < 0 {
+			return
+		}
+		start := offset + i
+		end, depth := -1, 0
+	scan:
+		for j := start + len(plaintextMarkerPrefix); j < len(s); j++ {
+			switch s[j] {
+			case '(':
+				depth++
+			case ')':
+				if depth == 0 {
+					end = j + 1
+					break scan
+				}
+				depth--
+			}
+		}
+		if end < 0 {
+			return
+		}
+		locations = append(locations, envelopeLocation{start: start, end: end})
+		offset = end
+	}
+}
+
+// hasUnclosedPlaintextMarker returns whether s has a plaintext marker without the parenthesis that closes it; as
+// findPlaintextMarkers stops at such a marker, it's the one that follows the last marker it returns
+func hasUnclosedPlaintextMarker(s string) bool {
+
+	offset := 0
+	if locations := findPlaintextMarkers(s); len(locations) > 0 {
+		offset = locations[len(locations)-1].end
+	}
+
+	return strings.Contains(s[offset:], plaintextMarkerPrefix)
+}
+
+// findPlaintextMarkerOutsideValues returns the offset of the first plaintext marker in the manifest that isn't in the
+// source of one of the values, like one in a comment or a key
+func (s *manifestSource) findPlaintextMarkerOutsideValues(scalars []manifestScalar) (offset int, ok bool) {
+
+	for start := 0; ; {
+		i := strings.Index(s.text[start:], plaintextMarkerPrefix)
+		if i < 0 {
+			return 0, false
+		}
+		offset = start + i
+		inValue := false
+		for _, scalar := range scalars {
+			if scalar.start >= 0 && scalar.start <= offset && offset < scalar.end {
+				inValue = true
+				break
+			}
+		}
+		if !inValue {
+			return offset, true
+		}
+		start = offset + len(plaintextMarkerPrefix)
+	}
+}
+
+// manifestSource is the text of a manifest with the offsets its lines start at
+type manifestSource struct {
+	text       string
+	lineStarts []int
+}
+
+// manifestScalar is a scalar value in a manifest with the span of its source
+type manifestScalar struct {
+	path  string
+	value string
+	style yaml.Style
+	// start and end delimit the source of the value, after its anchor and tag; they're -1 for empty plain values, which
+	// have no source, and end is -1 for plain values spanning several lines
+	start int
+	end   int
+	// indent is the indentation of the content of a literal or folded value
+	indent string
+	// flow is whether the value is inside a flow collection, which holds every value of a JSON document
+	flow bool
+	// null is whether the value is null, like ~ or an empty plain value
+	null bool
+}
+
+// manifestEdit replaces the source of a scalar
+type manifestEdit struct {
+	// raw is the new source of the scalar in its own style, or empty if the value can't be written in that style
+	raw string
+	// value is the value the scalar has after the edit
+	value string
+}
+
+// parseManifest returns every scalar value in the documents of the manifest, in order of appearance
+func parseManifest(manifest string) (source *manifestSource, scalars []manifestScalar, err error) {
+
+	source = &manifestSource{
+		text:       manifest,
+		lineStarts: []int{0},
+	}
+	for i := 0; i < len(manifest); i++ {
+		if manifest[i] == '\n' {
+			source.lineStarts = append(source.lineStarts, i+1)
+		}
+	}
+
+	decoder := yaml.NewDecoder(strings.NewReader(manifest))
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+		}
+		scalars = source.walk(&document, "", -1, false, scalars)
+	}
+
+	return source, scalars, nil
+}
+
+// walk appends the scalar values in the node to scalars; parentIndent is the indentation of the key or sequence the node
+// is the value of, which the content of a literal or folded value has to exceed
+func (s *manifestSource) walk(node *yaml.Node, path string, parentIndent int, flow bool, scalars []manifestScalar) []manifestScalar {
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			scalars = s.walk(child, path, -1, flow, scalars)
+		}
+	case yaml.MappingNode:
+		flow = flow || node.Style&yaml.FlowStyle != 0
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			scalars = s.walk(node.Content[i+1], joinManifestPath(path, key.Value), key.Column-1, flow, scalars)
+		}
+	case yaml.SequenceNode:
+		flow = flow || node.Style&yaml.FlowStyle != 0
+		for i, child := range node.Content {
+			scalars = s.walk(child, fmt.Sprintf("%v[%v]", path, i), node.Column-1, flow, scalars)
+		}
+	case yaml.ScalarNode:
+		scalars = append(scalars, s.scalar(node, path, parentIndent, flow))
+	}
+
+	// aliases are skipped, the value they refer to is visited where it's anchored
+	return scalars
+}
+
+// joinManifestPath appends the key to the path, quoting keys that would be ambiguous in a path
+func joinManifestPath(path, key string) string {
+
+	if key == "" || strings.ContainsAny(key, `.[]"`) {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// scalar locates the source of the scalar node
+func (s *manifestSource) scalar(node *yaml.Node, path string, parentIndent int, flow bool) manifestScalar {
+
+	scalar := manifestScalar{
+		path:  path,
+		value: node.Value,
+		style: node.Style,
+		start: -1,
+		end:   -1,
+		flow:  flow,
+		null:  node.ShortTag() == "!!null",
+	}
+	quoted := node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) != 0
+	if node.Value == "" && !quoted {
+		return scalar
+	}
+
+	// the position of a node is the one of its anchor or tag if it has any
+	start := s.offset(node.Line, node.Column)
+	for start < len(s.text) && (s.text[start] == '&' || s.text[start] == '!') {
+		for start < len(s.text) && !isManifestSpace(s.text[start]) {
+			start++
+		}
+		for start < len(s.text) && isManifestSpace(s.text[start]) {
+			start++
+		}
+	}
+	scalar.start = start
+
+	switch {
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(s.text); i++ {
+			if s.text[i] == '\\' {
+				i++
+			} else if s.text[i] == '"' {
+				scalar.end = i + 1
+				break
+			}
+		}
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(s.text); i++ {
+			if s.text[i] == '\'' {
+				if i+1 < len(s.text) && s.text[i+1] == '\'' {
+					i++
+					continue
+				}
+				scalar.end = i + 1
+				break
+			}
+		}
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		scalar.end, scalar.indent = s.blockEnd(start, parentIndent)
+	default:
+		// a plain value ends at a comment, at the end of its line, or at a flow indicator inside a flow collection
+		end := start
+		for i := start; i < len(s.text) && s.text[i] != '\n'; i++ {
+			c := s.text[i]
+			if c == '#' && i > start && (s.text[i-1] == ' ' || s.text[i-1] == '\t') {
+				break
+			}
+			if flow && (c == ',' || c == ']' || c == '}') {
+				break
+			}
+			if !isManifestSpace(c) {
+				end = i + 1
+			}
+		}
+		if s.text[start:end] == node.Value {
+			scalar.end = end
+		}
+	}
+
+	return scalar
+}
+
+// blockEnd returns the end of the last line of content of the literal or folded value with the header at start, and the
+// indentation of its content
+func (s *manifestSource) blockEnd(start, parentIndent int) (end int, indent string) {
+
+	end = strings.IndexByte(s.text[start:], '\n')
+	if end < 0 {
+		return len(s.text), ""
+	}
+	end += start
+
+	for lineStart := end + 1; lineStart < len(s.text); {
+		lineEnd := strings.IndexByte(s.text[lineStart:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(s.text)
+		} else {
+			lineEnd += lineStart
+		}
+		line := s.text[lineStart:lineEnd]
+		if strings.TrimLeft(line, " \t\r") != "" {
+			n := len(line) - len(strings.TrimLeft(line, " "))
+			if n <= parentIndent || (indent != "" && n < len(indent)) {
+				break
+			}
+			if indent == "" {
+				indent = line[:n]
+			}
+			end = lineEnd
+		}
+		lineStart = lineEnd + 1
+	}
+
+	return end, indent
+}
+
+// offset returns the byte offset of a line and column as reported by the YAML parser, which counts characters
+func (s *manifestSource) offset(line, column int) int {
+
+	if line < 1 || line > len(s.lineStarts) {
+		return len(s.text)
+	}
+	offset := s.lineStarts[line-1]
+	for c := 1; c < column && offset < len(s.text); c++ {
+		_, size := utf8.DecodeRuneInString(s.text[offset:])
+		offset += size
+	}
+
+	return offset
+}
+
+// position returns the line and column of a byte offset, both starting at 1
+func (s *manifestSource) position(offset int) (line, column int) {
+
+	line = sort.Search(len(s.lineStarts), func(i int) bool { return s.lineStarts[i] > offset })
+
+	return line, utf8.RuneCountInString(s.text[s.lineStarts[line-1]:offset]) + 1
+}
+
+// raw returns the source of the scalar
+func (s *manifestSource) raw(scalar manifestScalar) (string, error) {
+
+	if scalar.start < 0 || scalar.end < 0 {
+		return "", fmt.Errorf("%w: the value at %v spans several lines without quotes", ErrInvalidManifest, scalar.path)
+	}
+
+	return s.text[scalar.start:scalar.end], nil
+}
+
+// rewrite applies the edits to the source and checks that every scalar ends up with the value it should have; edits that
+// can't be made in the style of the scalar, or that turn out to change what the manifest means, are written as
+// double-quoted strings instead
+func (s *manifestSource) rewrite(scalars []manifestScalar, edits map[int]manifestEdit) (string, error) {
+
+	if len(edits) == 0 {
+		return s.text, nil
+	}
+
+	indexes := make([]int, 0, len(edits))
+	for i := range edits {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	quoted := map[int]bool{}
+	for {
+		var sb strings.Builder
+		end := 0
+		for _, i := range indexes {
+			raw := edits[i].raw
+			if raw == "" || quoted[i] {
+				raw = quoteManifestValue(edits[i].value)
+			}
+			sb.WriteString(s.text[end:scalars[i].start])
+			sb.WriteString(raw)
+			end = scalars[i].end
+		}
+		sb.WriteString(s.text[end:])
+		output := sb.String()
+
+		_, rewrittenScalars, err := parseManifest(output)
+		if err != nil || len(rewrittenScalars) != len(scalars) {
+			// nothing tells which edit broke the manifest, so all of them are quoted
+			if len(quoted) == len(indexes) {
+				return "", fmt.Errorf("%w: rewriting the values breaks the manifest", ErrInvalidManifest)
+			}
+			for _, i := range indexes {
+				quoted[i] = true
+			}
+			continue
+		}
+
+		retry := false
+		for i, scalar := range rewrittenScalars {
+			want := scalars[i].value
+			edit, edited := edits[i]
+			if edited {
+				want = edit.value
+			}
+			if scalar.path == scalars[i].path && scalar.value == want {
+				continue
+			}
+			if !edited || quoted[i] {
+				return "", fmt.Errorf("%w: rewriting the value at %v changes the manifest", ErrInvalidManifest, scalars[i].path)
+			}
+			quoted[i] = true
+			retry = true
+		}
+		if !retry {
+			return output, nil
+		}
+	}
+}
+
+// encode returns the text in the style of the scalar, to replace part of its source, or false if the style can't hold it
+func (scalar manifestScalar) encode(text string) (string, bool) {
+
+	switch {
+	case scalar.style&yaml.DoubleQuotedStyle != 0:
+		quoted := quoteManifestValue(text)
+		return quoted[1 : len(quoted)-1], true
+	case scalar.style&yaml.SingleQuotedStyle != 0:
+		return strings.ReplaceAll(text, "'", "''"), !strings.ContainsAny(text, "\r\n")
+	case scalar.style&yaml.LiteralStyle != 0:
+		if strings.HasSuffix(text, "\n") || strings.Contains(text, "\r") {
+			return "", false
+		}
+		return strings.ReplaceAll(text, "\n", "\n"+scalar.indent), true
+	default:
+		return text, !strings.ContainsAny(text, "\r\n")
+	}
+}
+
+// quoteManifestValue returns the value as a double-quoted string, using only escapes that JSON understands as well
+func quoteManifestValue(value string) string {
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f || r == 0x85 || r == 0x2028 || r == 0x2029 || r == utf8.RuneError {
+				fmt.Fprintf(&sb, `\u%04x`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+
+	return sb.String()
+}
+
+func isManifestSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,264 @@
+package crypt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// MaskPlaceholder replaces the secret values in the output of a MaskingWriter
+const MaskPlaceholder = "***"
+
+// MaskingWriter is an io.WriteCloser that replaces secret values in what's written to it with MaskPlaceholder before
+// passing it on; it holds back the end of a write that may be the start of a secret value until the next write shows
+// whether it is, or until Flush or Close
+type MaskingWriter interface {
+	io.WriteCloser
+	// Flush passes on the held back bytes; a secret value split by a flush isn't masked
+	Flush() error
+}
+
+type maskingWriter struct {
+	w       io.Writer
+	matcher *formMatcher
+	pending []byte
+	out     []byte
+	mu      sync.Mutex
+}
+
+// NewMaskingWriter returns a MaskingWriter that masks the values, and their base64, URL-encoded and JSON-escaped forms,
+// in what's written to w; Close flushes it but doesn't close w
+func NewMaskingWriter(w io.Writer, values ...string) MaskingWriter {
+
+	seen := map[string]bool{}
+	var forms [][]byte
+	for _, value := range values {
+		for _, form := range maskedForms(value) {
+			if form != "" && !seen[form] {
+				seen[form] = true
+				forms = append(forms, []byte(form))
+			}
+		}
+	}
+
+	return &maskingWriter{
+		w:       w,
+		matcher: newFormMatcher(forms),
+	}
+}
+
+// NewMaskingWriterForManifest returns a MaskingWriter that masks the values of all secrets in the manifest that decrypt
+// for the scope; secrets restricted to another scope or outside their validity are skipped, as they can't be decrypted
+// into a log of this scope, but any other failure to decrypt fails it
+func NewMaskingWriterForManifest(w io.Writer, secretHelper SecretHelper, manifest string, scope Scope) (MaskingWriter, error) {
+
+	var values []string
+	for _, loc := range findAllEnvelopes(manifest) {
+		envelope := manifest[loc.start:loc.end]
+		value, _, err := secretHelper.DecryptEnvelopeContext(context.Background(), envelope, scope)
+		if err != nil {
+			if isDeniedSecretError(err) {
+				continue
+			}
+			return nil, secretErrorAt(err, envelope, loc.start)
+		}
+		values = append(values, value)
+	}
+
+	return NewMaskingWriter(w, values...), nil
+}
+
+// maskedForms returns the forms in which a secret value can end up in a build log
+func maskedForms(value string) []string {
+
+	if value == "" {
+		return nil
+	}
+
+	forms := []string{
+		value,
+		base64.StdEncoding.EncodeToString([]byte(value)),
+		base64.URLEncoding.EncodeToString([]byte(value)),
+		base64.RawStdEncoding.EncodeToString([]byte(value)),
+		base64.RawURLEncoding.EncodeToString([]byte(value)),
+		url.QueryEscape(value),
+		url.PathEscape(value),
+	}
+
+	// json.Marshal escapes <, > and & while an encoder can be told not to, so both forms are masked
+	if escaped, err := json.Marshal(value); err == nil {
+		forms = append(forms, string(escaped[1:len(escaped)-1]))
+	}
+	var sb strings.Builder
+	encoder := json.NewEncoder(&sb)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err == nil {
+		escaped := strings.TrimSuffix(sb.String(), "\n")
+		forms = append(forms, escaped[1:len(escaped)-1])
+	}
+
+	return forms
+}
+
+func (mw *maskingWriter) Write(p []byte) (n int, err error) {
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	mw.pending = append(mw.pending, p...)
+	if err = mw.mask(false); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (mw *maskingWriter) Flush() error {
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	return mw.mask(true)
+}
+
+func (mw *maskingWriter) Close() error {
+	return mw.Flush()
+}
+
+// mask passes on the pending bytes with the secret values replaced, holding back the ones that may be the start of a
+// secret value unless it's final
+func (mw *maskingWriter) mask(final bool) error {
+
+	m := mw.matcher
+	text := mw.pending
+	out := mw.out[:0]
+
+	// emitted is where the bytes that aren't passed on yet start; after every masked value the scan starts over from
+	// there, so a match that overlaps the masked value is dropped and one that follows it is found again
+	emitted, hold := 0, len(text)
+	for emitted < len(text) {
+		state, matchStart, matchEnd := int32(0), -1, -1
+		i := emitted
+		for ; i < len(text); i++ {
+			state = m.next(state, text[i])
+			if n := int(m.match[state]); n > 0 && (matchStart < 0 || i+1-n <= matchStart) {
+				matchStart, matchEnd = i+1-n, i+1
+			}
+			// the match is final once no form that's still being matched starts at or before it
+			if matchStart >= 0 && i+1-int(m.extendable[state]) > matchStart {
+				break
+			}
+		}
+		if matchStart >= 0 && (i < len(text) || final) {
+			out = append(out, text[emitted:matchStart]...)
+			out = append(out, MaskPlaceholder...)
+			emitted = matchEnd
+			continue
+		}
+		if !final {
+			hold = len(text) - int(m.extendable[state])
+			if matchStart >= 0 && matchStart < hold {
+				hold = matchStart
+			}
+		}
+		out = append(out, text[emitted:hold]...)
+		break
+	}
+	mw.pending = append(mw.pending[:0], text[hold:]...)
+	mw.out = out
+
+	if len(out) == 0 {
+		return nil
+	}
+	_, err := mw.w.Write(out)
+
+	return err
+}
+
+// formMatcher is an Aho-Corasick automaton that finds the forms of secret values in a single pass over the bytes, with
+// its transitions in a table indexed by state and byte class so a step is a single lookup
+type formMatcher struct {
+	classes    [256]uint16
+	numClasses int
+	delta      []int32
+	// match is the length of the longest form that ends in a state, or 0 if there's none
+	match []int32
+	// extendable is the length of the longest form prefix that ends in a state and can still grow into a longer form
+	extendable []int32
+}
+
+func newFormMatcher(forms [][]byte) *formMatcher {
+
+	m := &formMatcher{numClasses: 1}
+	for _, form := range forms {
+		for _, b := range form {
+			if m.classes[b] == 0 {
+				m.classes[b] = uint16(m.numClasses)
+				m.numClasses++
+			}
+		}
+	}
+
+	// a trie of the forms; a transition to the root means there's no child, as no form leads back to it
+	m.delta = make([]int32, m.numClasses)
+	m.match = []int32{0}
+	depth := []int32{0}
+	for _, form := range forms {
+		state := int32(0)
+		for _, b := range form {
+			t := int(state)*m.numClasses + int(m.classes[b])
+			if m.delta[t] == 0 {
+				m.delta[t] = int32(len(m.match))
+				m.delta = append(m.delta, make([]int32, m.numClasses)...)
+				m.match = append(m.match, 0)
+				depth = append(depth, depth[state]+1)
+			}
+			state = m.delta[t]
+		}
+		m.match[state] = int32(len(form))
+	}
+
+	// breadth first, every missing transition of a state takes the one of its longest proper suffix in the trie
+	m.extendable = make([]int32, len(m.match))
+	fail := make([]int32, len(m.match))
+	queue := []int32{0}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		row := m.delta[int(state)*m.numClasses : int(state+1)*m.numClasses]
+		failRow := m.delta[int(fail[state])*m.numClasses : int(fail[state]+1)*m.numClasses]
+		hasChildren := false
+		for c := range row {
+			child := row[c]
+			if child == 0 {
+				if state != 0 {
+					row[c] = failRow[c]
+				}
+				continue
+			}
+			hasChildren = true
+			if state != 0 {
+				fail[child] = failRow[c]
+			}
+			if m.match[child] == 0 {
+				m.match[child] = m.match[fail[child]]
+			}
+			queue = append(queue, child)
+		}
+		if hasChildren {
+			m.extendable[state] = depth[state]
+		} else {
+			m.extendable[state] = m.extendable[fail[state]]
+		}
+	}
+
+	return m
+}
+
+func (m *formMatcher) next(state int32, b byte) int32 {
+	return m.delta[int(state)*m.numClasses+int(m.classes[b])]
+}
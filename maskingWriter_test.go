@@ -0,0 +1,182 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskingWriter(t *testing.T) {
+
+	t.Run("MasksValue", func(t *testing.T) {
+
+		var output bytes.Buffer
+		mw := NewMaskingWriter(&output, "this is my secret")
+
+		// act
+		_, err := mw.Write([]byte("echo this is my secret\n"))
+		assert.Nil(t, err)
+		err = mw.Close()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "echo ***\n", output.String())
+	})
+
+	t.Run("MasksEncodedFormsOfValue", func(t *testing.T) {
+
+		var output bytes.Buffer
+		mw := NewMaskingWriter(&output, `p@ss w/rd"<&>`)
+
+		// act
+		_, err := mw.Write([]byte(base64.StdEncoding.EncodeToString([]byte(`p@ss w/rd"<&>`)) + " " + base64.URLEncoding.EncodeToString([]byte(`p@ss w/rd"<&>`)) + "\n"))
+		assert.Nil(t, err)
+		_, err = mw.Write([]byte(`?password=p%40ss+w%2Frd%22%3C%26%3E /p@ss%20w%2Frd%22%3C&%3E` + "\n"))
+		assert.Nil(t, err)
+		_, err = mw.Write([]byte(`{"password":"p@ss w/rd\"<&>","other":"p@ss w/rd\"<&>"}` + "\n"))
+		assert.Nil(t, err)
+		err = mw.Close()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "*** ***\n?password=*** /***\n{\"password\":\"***\",\"other\":\"***\"}\n", output.String())
+	})
+
+	t.Run("MasksValueSplitAcrossWrites", func(t *testing.T) {
+
+		var output bytes.Buffer
+		mw := NewMaskingWriter(&output, "this is my secret")
+
+		// act
+		for _, b := range []byte("echo this is my secret; echo this is my") {
+			_, err := mw.Write([]byte{b})
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, "echo ***; echo ", output.String())
+		err := mw.Close()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "echo ***; echo this is my", output.String())
+	})
+
+	t.Run("MasksLongestOfOverlappingValues", func(t *testing.T) {
+
+		var output bytes.Buffer
+		mw := NewMaskingWriter(&output, "secret", "secret value")
+
+		// act
+		_, err := mw.Write([]byte("a secret"))
+		assert.Nil(t, err)
+		_, err = mw.Write([]byte(" value and a secret\n"))
+		assert.Nil(t, err)
+		err = mw.Close()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "a *** and a ***\n", output.String())
+	})
+
+	t.Run("IgnoresEmptyValue", func(t *testing.T) {
+
+		var output bytes.Buffer
+		mw := NewMaskingWriter(&output, "")
+
+		// act
+		_, err := mw.Write([]byte("echo\n"))
+
+		assert.Nil(t, err)
+		assert.Equal(t, "echo\n", output.String())
+	})
+}
+
+func TestNewMaskingWriterForManifest(t *testing.T) {
+
+	t.Run("MasksValuesOfSecretsInManifest", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		var output bytes.Buffer
+		mw, err := NewMaskingWriterForManifest(&output, secretHelper, "key: "+envelope, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"})
+		assert.Nil(t, err)
+
+		// act
+		_, err = mw.Write([]byte("echo this is my secret\n"))
+
+		assert.Nil(t, err)
+		assert.Equal(t, "echo ***\n", output.String())
+	})
+
+	t.Run("SkipsSecretsRestrictedToOtherScopesOrExpired", func(t *testing.T) {
+
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithClock(func() time.Time { return now }))
+		deploy, err := secretHelper.EncryptEnvelope("deploy secret", DefaultPipelineAllowList, RestrictToStages("deploy"))
+		assert.Nil(t, err)
+		web, err := secretHelper.EncryptEnvelope("web secret", "github.com/ziplineeci/ziplinee-ci-web")
+		assert.Nil(t, err)
+		expired, err := secretHelper.EncryptEnvelope("expired secret", DefaultPipelineAllowList, ExpiresAt(now.Add(-time.Hour)))
+		assert.Nil(t, err)
+		build, err := secretHelper.EncryptEnvelope("build secret", DefaultPipelineAllowList, RestrictToStages("build"))
+		assert.Nil(t, err)
+		manifest := "a: " + deploy + "\nb: " + web + "\nc: " + expired + "\nd: " + build
+		var output bytes.Buffer
+		mw, err := NewMaskingWriterForManifest(&output, secretHelper, manifest, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api", Stage: "build"})
+		assert.Nil(t, err)
+
+		// act
+		_, err = mw.Write([]byte("echo build secret\n"))
+
+		assert.Nil(t, err)
+		assert.Equal(t, "echo ***\n", output.String())
+	})
+
+	t.Run("ReturnsErrorIfSecretFailsToDecrypt", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		var output bytes.Buffer
+
+		// act
+		_, err := NewMaskingWriterForManifest(&output, secretHelper, "key: ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u)", Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"})
+
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+		var secretErr *SecretError
+		if assert.True(t, errors.As(err, &secretErr)) {
+			assert.Equal(t, 5, secretErr.Offset)
+		}
+	})
+}
+
+func BenchmarkMaskingWriter(b *testing.B) {
+
+	values := make([]string, 50)
+	for i := range values {
+		values[i] = fmt.Sprintf("this is secret number %v", i)
+	}
+	var sb strings.Builder
+	for i := 0; sb.Len() < 10*1024*1024; i++ {
+		fmt.Fprintf(&sb, "Step %v/120 : RUN go build -o ./publish/app ./cmd/app && echo 'built at 2024-01-01T00:00:00Z'\n", i%120)
+		if i%100 == 0 {
+			fmt.Fprintf(&sb, "docker login --password %v\n", values[i%len(values)])
+		}
+	}
+	log := []byte(sb.String())
+	b.SetBytes(int64(len(log)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		mw := NewMaskingWriter(io.Discard, values...)
+		for start := 0; start < len(log); start += 32 * 1024 {
+			if _, err := mw.Write(log[start:min(start+32*1024, len(log))]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := mw.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package crypt
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// regexAllowListEntryPrefix marks an entry of a pipeline allow list that is a regular expression
+const regexAllowListEntryPrefix = "regex:"
+
+// globAllowListEntryRegex is the regular expression an entry of a pipeline allow list that isn't a regular expression has
+// to match; it's a pipeline name in which '*' matches any part of a path segment and '?' any single character
+var globAllowListEntryRegex = regexp.MustCompile(`^[a-zA-Z0-9._/*?-]+$`)
+
+// pipelineNameRegex is the regular expression a legacy pipeline allow list has to match to be converted to an exact entry
+var pipelineNameRegex = regexp.MustCompile(`^[a-zA-Z0-9._/-]+$`)
+
+// pipelineAllowList is the parsed pipeline allow list of a v2 secret
+type pipelineAllowList struct {
+	anyPipeline bool
+	exact       map[string]bool
+	globs       []string
+	regexes     []*regexp.Regexp
+}
+
+// ValidatePipelineAllowList returns an error wrapping ErrInvalidPipelineAllowList if the pipeline allow list can't be
+// used to encrypt a secret; a pipeline allow list is a comma separated list of exact pipeline names like
+// github.com/org/repo, globs like github.com/org/* and regular expressions prefixed with regex:, or .* to allow any
+// pipeline; a regular expression runs to the end of the list, so it may contain commas but has to be the last entry
+func ValidatePipelineAllowList(allowList string) error {
+
+	_, err := parsePipelineAllowList(allowList)
+
+	return err
+}
+
+func parsePipelineAllowList(allowList string) (l *pipelineAllowList, err error) {
+
+	l = &pipelineAllowList{
+		exact: map[string]bool{},
+	}
+
+	for _, entry := range splitPipelineAllowList(allowList) {
+		switch {
+		case entry == "":
+			return nil, fmt.Errorf("%w: %q has an empty entry", ErrInvalidPipelineAllowList, allowList)
+
+		case entry == DefaultPipelineAllowList:
+			l.anyPipeline = true
+
+		case strings.HasPrefix(entry, regexAllowListEntryPrefix):
+			pattern := strings.TrimPrefix(entry, regexAllowListEntryPrefix)
+			r, err := regexp.Compile(fmt.Sprintf("^(?:%v)$", pattern))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidPipelineAllowList, err)
+			}
+			l.regexes = append(l.regexes, r)
+
+		case strings.Contains(entry, ".*") || !globAllowListEntryRegex.MatchString(entry):
+			return nil, fmt.Errorf("%w: %q isn't a pipeline name or glob; use a glob like github.com/org/* or prefix a regular expression with %v", ErrInvalidPipelineAllowList, entry, regexAllowListEntryPrefix)
+
+		case strings.ContainsAny(entry, "*?"):
+			l.globs = append(l.globs, entry)
+
+		default:
+			l.exact[entry] = true
+		}
+	}
+
+	return l, nil
+}
+
+// allows returns whether any of the entries matches the pipeline
+func (l *pipelineAllowList) allows(pipeline string) bool {
+
+	if l.anyPipeline || l.exact[pipeline] {
+		return true
+	}
+	for _, glob := range l.globs {
+		if matched, _ := path.Match(glob, pipeline); matched {
+			return true
+		}
+	}
+	for _, r := range l.regexes {
+		if r.MatchString(pipeline) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizePipelineAllowList validates the pipeline allow list and returns it with its entries trimmed, or the default
+// pipeline allow list if it's empty
+func normalizePipelineAllowList(allowList string) (string, error) {
+
+	allowList = strings.TrimSpace(allowList)
+	if allowList == "" {
+		return DefaultPipelineAllowList, nil
+	}
+
+	if err := ValidatePipelineAllowList(allowList); err != nil {
+		return "", err
+	}
+
+	return strings.Join(splitPipelineAllowList(allowList), ","), nil
+}
+
+// splitPipelineAllowList returns the trimmed entries of the pipeline allow list, with a regex: entry running to the end
+func splitPipelineAllowList(allowList string) (entries []string) {
+
+	for remaining, more := allowList, true; more; {
+		var entry string
+		entry, remaining, more = strings.Cut(remaining, ",")
+		entry = strings.TrimSpace(entry)
+		if strings.HasPrefix(entry, regexAllowListEntryPrefix) && more {
+			entry, more = strings.TrimSpace(entry+","+remaining), false
+		}
+		entries = append(entries, entry)
+	}
+
+	return
+}
+
+// compileLegacyPipelineAllowList compiles the regular expression pipeline allow list of a legacy secret
+func compileLegacyPipelineAllowList(allowList string) (*regexp.Regexp, error) {
+
+	return regexp.Compile(fmt.Sprintf("^%v$", allowList))
+}
+
+// maxCachedAllowLists bounds the number of pipeline allow lists an allowListCache holds; when it's reached the cache
+// starts over
+const maxCachedAllowLists = 256
+
+// allowListCache holds the matchers of the pipeline allow lists a secret helper came across, since the secrets in a
+// manifest share a few allow lists and compiling their regular expressions for every secret is costly; it's usable as
+// its zero value
+type allowListCache struct {
+	mu       sync.RWMutex
+	matchers map[allowListCacheKey]func(pipeline string) bool
+}
+
+type allowListCacheKey struct {
+	allowList string
+	legacy    bool
+}
+
+// matcher returns the function that tells whether the pipeline allow list, of a legacy secret or not, allows a pipeline
+func (c *allowListCache) matcher(allowList string, legacy bool) (matcher func(pipeline string) bool, err error) {
+
+	key := allowListCacheKey{allowList: allowList, legacy: legacy}
+
+	c.mu.RLock()
+	matcher, ok := c.matchers[key]
+	c.mu.RUnlock()
+	if ok {
+		return matcher, nil
+	}
+
+	if legacy {
+		r, err := compileLegacyPipelineAllowList(allowList)
+		if err != nil {
+			return nil, err
+		}
+		matcher = r.MatchString
+	} else {
+		l, err := parsePipelineAllowList(allowList)
+		if err != nil {
+			return nil, err
+		}
+		matcher = l.allows
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.matchers == nil || len(c.matchers) >= maxCachedAllowLists {
+		c.matchers = map[allowListCacheKey]func(pipeline string) bool{}
+	}
+	c.matchers[key] = matcher
+
+	return matcher, nil
+}
+
+// convertLegacyPipelineAllowList converts the regular expression pipeline allow list of a legacy secret to a v2
+// pipeline allow list; pipeline names without regular expression operators become exact entries, anything else a
+// regex: entry
+func convertLegacyPipelineAllowList(allowList string) string {
+
+	if allowList == DefaultPipelineAllowList {
+		return allowList
+	}
+	if pipelineNameRegex.MatchString(allowList) {
+		return allowList
+	}
+
+	return regexAllowListEntryPrefix + allowList
+}
+
+// isLegacySecret returns whether the secret is in the legacy format, whose pipeline allow list is a regular expression
+func isLegacySecret(encryptedTextPlusNonce string) bool {
+
+	format, _, _ := strings.Cut(strings.TrimPrefix(encryptedTextPlusNonce, "ziplinee.secret("), ".")
+
+	return format != secretFormatV2 && format != secretFormatV2WrappedKey
+}
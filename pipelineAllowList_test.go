@@ -0,0 +1,92 @@
+package crypt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePipelineAllowList(t *testing.T) {
+
+	t.Run("ReturnsNilForValidPipelineAllowLists", func(t *testing.T) {
+
+		for _, allowList := range []string{
+			".*",
+			"github.com/ziplineeci/ziplinee-ci-api",
+			"github.com/ziplineeci/*",
+			"github.com/ziplineeci/ziplinee-ci-api, bitbucket.org/ziplineeci/ziplinee-ci-?pi",
+			"github.com/ziplineeci/ziplinee-ci-api,regex:github\\.com/(ziplineeci|other)/.+",
+			"regex:github\\.com/ziplineeci/[a-z]{1,20}",
+		} {
+			// act
+			err := ValidatePipelineAllowList(allowList)
+
+			assert.Nil(t, err, allowList)
+		}
+	})
+
+	t.Run("ReturnsErrInvalidPipelineAllowListForInvalidPipelineAllowLists", func(t *testing.T) {
+
+		for _, allowList := range []string{
+			"github.com/ziplineeci/.*",
+			"github.com/ziplineeci/.+",
+			"github.com/(ziplineeci|other)/repo",
+			"github.com/ziplineeci/ziplinee-ci-api,",
+			"regex:github.com/(ziplineeci",
+		} {
+			// act
+			err := ValidatePipelineAllowList(allowList)
+
+			assert.True(t, errors.Is(err, ErrInvalidPipelineAllowList), allowList)
+		}
+	})
+}
+
+func TestPipelineAllowListAllows(t *testing.T) {
+
+	t.Run("ReturnsTrueForExactGlobAndRegexMatches", func(t *testing.T) {
+
+		allowList, err := parsePipelineAllowList("github.com/ziplineeci/ziplinee-ci-api, bitbucket.org/ziplineeci/*, regex:gitlab\\.com/(ziplineeci|other)/web")
+		assert.Nil(t, err)
+
+		// act
+		assert.True(t, allowList.allows("github.com/ziplineeci/ziplinee-ci-api"))
+		assert.True(t, allowList.allows("bitbucket.org/ziplineeci/ziplinee-ci-web"))
+		assert.True(t, allowList.allows("gitlab.com/other/web"))
+		assert.False(t, allowList.allows("githubXcom/ziplineeci/ziplinee-ci-api"))
+		assert.False(t, allowList.allows("bitbucket.org/ziplineeci/nested/repo"))
+		assert.False(t, allowList.allows("gitlab.com/other/web-extra"))
+	})
+
+	t.Run("ReturnsTrueForAnyPipelineIfPipelineAllowListIsDefault", func(t *testing.T) {
+
+		allowList, err := parsePipelineAllowList(DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		allows := allowList.allows("github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, allows)
+	})
+}
+
+func TestConvertLegacyPipelineAllowList(t *testing.T) {
+
+	t.Run("ReturnsExactEntryForPipelineName", func(t *testing.T) {
+
+		// act
+		allowList := convertLegacyPipelineAllowList("github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", allowList)
+	})
+
+	t.Run("ReturnsRegexEntryForRegularExpression", func(t *testing.T) {
+
+		// act
+		allowList := convertLegacyPipelineAllowList("github.com/ziplineeci/ziplinee-ci-(api|web){1,2}")
+
+		assert.Equal(t, "regex:github.com/ziplineeci/ziplinee-ci-(api|web){1,2}", allowList)
+		assert.Nil(t, ValidatePipelineAllowList(allowList))
+	})
+}
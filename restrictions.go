@@ -0,0 +1,164 @@
+package crypt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// secretRestrictions are the restrictions sealed in a v2 secret; a secret that's only restricted by its pipeline allow
+// list seals just that, any other secret seals all its restrictions as json
+type secretRestrictions struct {
+	PipelineAllowList string   `json:"pipelines"`
+	Branches          []string `json:"branches,omitempty"`
+	Events            []string `json:"events,omitempty"`
+	Stages            []string `json:"stages,omitempty"`
+	ReleaseTargets    []string `json:"releaseTargets,omitempty"`
+	// NotBefore and ExpiresAt are unix timestamps in seconds, or 0 if the secret is valid from or until any time
+	NotBefore int64 `json:"notBefore,omitempty"`
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+// scopeRestriction is a restriction on a single field of a scope
+type scopeRestriction struct {
+	name     string
+	patterns []string
+	value    string
+}
+
+// newSecretRestrictions returns the validated restrictions for a secret to encrypt
+func newSecretRestrictions(pipelineAllowList string, options []EncryptOption) (r secretRestrictions, err error) {
+
+	r.PipelineAllowList, err = normalizePipelineAllowList(pipelineAllowList)
+	if err != nil {
+		return
+	}
+
+	for _, option := range options {
+		option(&r)
+	}
+
+	for _, restriction := range r.scopeRestrictions(Scope{}) {
+		for i, pattern := range restriction.patterns {
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				return r, fmt.Errorf("%w: the %v restriction has an empty entry", ErrInvalidRestriction, restriction.name)
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return r, fmt.Errorf("%w: the %v restriction %q: %v", ErrInvalidRestriction, restriction.name, pattern, err)
+			}
+			restriction.patterns[i] = pattern
+		}
+	}
+
+	if r.NotBefore != 0 && r.ExpiresAt != 0 && r.ExpiresAt <= r.NotBefore {
+		return r, fmt.Errorf("%w: the secret expires before it becomes valid", ErrInvalidRestriction)
+	}
+
+	return r, nil
+}
+
+// parseSecretRestrictions parses the sealed restrictions of a v2 secret
+func parseSecretRestrictions(sealedRestrictions string) (r secretRestrictions, err error) {
+
+	if !strings.HasPrefix(sealedRestrictions, "{") {
+		return secretRestrictions{PipelineAllowList: sealedRestrictions}, nil
+	}
+
+	if err = json.Unmarshal([]byte(sealedRestrictions), &r); err != nil {
+		return r, err
+	}
+	if r.PipelineAllowList == "" {
+		return r, errors.New("the restrictions have no pipeline allow list")
+	}
+
+	return r, nil
+}
+
+// encode returns the restrictions as they're sealed in a secret
+func (r secretRestrictions) encode() (string, error) {
+
+	if len(r.Branches) == 0 && len(r.Events) == 0 && len(r.Stages) == 0 && len(r.ReleaseTargets) == 0 && r.NotBefore == 0 && r.ExpiresAt == 0 {
+		return r.PipelineAllowList, nil
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// encryptOptions returns the options to encrypt a secret with the same restrictions other than the pipeline allow list
+func (r secretRestrictions) encryptOptions() (options []EncryptOption) {
+
+	if len(r.Branches) > 0 {
+		options = append(options, RestrictToBranches(r.Branches...))
+	}
+	if len(r.Events) > 0 {
+		options = append(options, RestrictToEvents(r.Events...))
+	}
+	if len(r.Stages) > 0 {
+		options = append(options, RestrictToStages(r.Stages...))
+	}
+	if len(r.ReleaseTargets) > 0 {
+		options = append(options, RestrictToReleaseTargets(r.ReleaseTargets...))
+	}
+	if r.NotBefore != 0 {
+		options = append(options, NotBefore(time.Unix(r.NotBefore, 0)))
+	}
+	if r.ExpiresAt != 0 {
+		options = append(options, ExpiresAt(time.Unix(r.ExpiresAt, 0)))
+	}
+
+	return
+}
+
+func (r secretRestrictions) scopeRestrictions(scope Scope) []scopeRestriction {
+	return []scopeRestriction{
+		{"branch", r.Branches, scope.Branch},
+		{"event", r.Events, scope.Event},
+		{"stage", r.Stages, scope.Stage},
+		{"release target", r.ReleaseTargets, scope.ReleaseTarget},
+	}
+}
+
+// checkScope returns an error naming the first restriction other than the pipeline allow list the scope doesn't match
+func (r secretRestrictions) checkScope(scope Scope) error {
+
+	for _, restriction := range r.scopeRestrictions(scope) {
+		if len(restriction.patterns) == 0 {
+			continue
+		}
+
+		allowed := false
+		for _, pattern := range restriction.patterns {
+			if matched, _ := path.Match(pattern, restriction.value); matched {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%v %q isn't allowed", restriction.name, restriction.value)
+		}
+	}
+
+	return nil
+}
+
+// checkValidity returns an error if the secret isn't valid yet or has expired at the given time
+func (r secretRestrictions) checkValidity(now time.Time) error {
+
+	if r.NotBefore != 0 && now.Before(time.Unix(r.NotBefore, 0)) {
+		return newSecretError(ErrSecretNotYetValid, fmt.Errorf("valid from %v", time.Unix(r.NotBefore, 0).UTC().Format(time.RFC3339)))
+	}
+	if r.ExpiresAt != 0 && !now.Before(time.Unix(r.ExpiresAt, 0)) {
+		return newSecretError(ErrSecretExpired, fmt.Errorf("expired at %v", time.Unix(r.ExpiresAt, 0).UTC().Format(time.RFC3339)))
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package crypt
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSecretRestrictions(t *testing.T) {
+
+	t.Run("ReturnsTrimmedRestrictions", func(t *testing.T) {
+
+		// act
+		restrictions, err := newSecretRestrictions(" github.com/ziplineeci/* ", []EncryptOption{RestrictToBranches(" main ", "release/*"), RestrictToEvents("release")})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "github.com/ziplineeci/*", restrictions.PipelineAllowList)
+		assert.Equal(t, []string{"main", "release/*"}, restrictions.Branches)
+		assert.Equal(t, []string{"release"}, restrictions.Events)
+	})
+
+	t.Run("ReturnsErrInvalidRestrictionIfGlobIsMalformed", func(t *testing.T) {
+
+		// act
+		_, err := newSecretRestrictions(DefaultPipelineAllowList, []EncryptOption{RestrictToStages("deploy-[")})
+
+		assert.True(t, errors.Is(err, ErrInvalidRestriction))
+	})
+
+	t.Run("ReturnsErrInvalidRestrictionIfSecretExpiresBeforeItBecomesValid", func(t *testing.T) {
+
+		notBefore := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+		// act
+		_, err := newSecretRestrictions(DefaultPipelineAllowList, []EncryptOption{NotBefore(notBefore), ExpiresAt(notBefore.Add(-time.Hour))})
+
+		assert.True(t, errors.Is(err, ErrInvalidRestriction))
+	})
+
+	t.Run("ReturnsErrInvalidRestrictionIfEntryIsEmpty", func(t *testing.T) {
+
+		// act
+		_, err := newSecretRestrictions(DefaultPipelineAllowList, []EncryptOption{RestrictToReleaseTargets("")})
+
+		assert.True(t, errors.Is(err, ErrInvalidRestriction))
+	})
+}
+
+func TestSecretRestrictionsEncode(t *testing.T) {
+
+	t.Run("ReturnsPipelineAllowListIfThereAreNoOtherRestrictions", func(t *testing.T) {
+
+		restrictions := secretRestrictions{PipelineAllowList: "github.com/ziplineeci/ziplinee-ci-api"}
+
+		// act
+		sealedRestrictions, err := restrictions.encode()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", sealedRestrictions)
+	})
+
+	t.Run("ReturnsJSONThatParsesIntoSameRestrictions", func(t *testing.T) {
+
+		restrictions := secretRestrictions{PipelineAllowList: ".*", Branches: []string{"main"}, Stages: []string{"deploy"}}
+
+		// act
+		sealedRestrictions, err := restrictions.encode()
+
+		assert.Nil(t, err)
+		assert.Equal(t, `{"pipelines":".*","branches":["main"],"stages":["deploy"]}`, sealedRestrictions)
+		parsedRestrictions, err := parseSecretRestrictions(sealedRestrictions)
+		assert.Nil(t, err)
+		assert.Equal(t, restrictions, parsedRestrictions)
+	})
+}
+
+func TestSecretRestrictionsCheckScope(t *testing.T) {
+
+	restrictions := secretRestrictions{
+		PipelineAllowList: DefaultPipelineAllowList,
+		Branches:          []string{"main", "release/*"},
+		Events:            []string{"release"},
+		ReleaseTargets:    []string{"production"},
+	}
+
+	t.Run("ReturnsNilIfScopeMatchesAllRestrictions", func(t *testing.T) {
+
+		// act
+		err := restrictions.checkScope(Scope{Branch: "release/1.0", Event: "release", Stage: "deploy", ReleaseTarget: "production"})
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfBranchIsNotAllowed", func(t *testing.T) {
+
+		// act
+		err := restrictions.checkScope(Scope{Branch: "feature/x", Event: "release", ReleaseTarget: "production"})
+
+		assert.EqualError(t, err, `branch "feature/x" isn't allowed`)
+	})
+
+	t.Run("ReturnsErrorIfScopeLacksRestrictedField", func(t *testing.T) {
+
+		// act
+		err := restrictions.checkScope(Scope{Branch: "main", Event: "release"})
+
+		assert.EqualError(t, err, `release target "" isn't allowed`)
+	})
+}
+
+func TestSecretRestrictionsCheckValidity(t *testing.T) {
+
+	restrictions := secretRestrictions{
+		PipelineAllowList: DefaultPipelineAllowList,
+		NotBefore:         time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		ExpiresAt:         time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC).Unix(),
+	}
+
+	t.Run("ReturnsNilBetweenNotBeforeAndExpiresAt", func(t *testing.T) {
+
+		// act
+		err := restrictions.checkValidity(time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC))
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrSecretNotYetValidBeforeNotBefore", func(t *testing.T) {
+
+		// act
+		err := restrictions.checkValidity(time.Date(2024, 5, 31, 23, 59, 59, 0, time.UTC))
+
+		assert.True(t, errors.Is(err, ErrSecretNotYetValid))
+	})
+
+	t.Run("ReturnsErrSecretExpiredAtExpiresAt", func(t *testing.T) {
+
+		// act
+		err := restrictions.checkValidity(time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC))
+
+		assert.True(t, errors.Is(err, ErrSecretExpired))
+	})
+}
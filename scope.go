@@ -0,0 +1,20 @@
+package crypt
+
+// Scope is what a secret is decrypted for; the restrictions of a secret are checked against it
+type Scope struct {
+	// Pipeline is the pipeline the secret is decrypted for, like github.com/ziplineeci/ziplinee-ci-api
+	Pipeline string
+	// Branch is the branch the build or release runs for
+	Branch string
+	// Event is the trigger of the build or release, like push, pull_request, release, cron or manual
+	Event string
+	// Stage is the name of the stage the secret is decrypted for
+	Stage string
+	// ReleaseTarget is the release target the secret is decrypted for, empty for builds
+	ReleaseTarget string
+}
+
+// pipelineScope returns the scope of the methods that only receive a pipeline
+func pipelineScope(pipeline string) Scope {
+	return Scope{Pipeline: pipeline}
+}
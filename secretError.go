@@ -0,0 +1,94 @@
+package crypt
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SecretError is returned when a secret fails to decrypt; use errors.Is with its Kind, e.g. ErrRestrictedSecret, to
+// find out why
+type SecretError struct {
+	// Envelope is the secret that failed to decrypt, in its envelope if it was passed in one
+	Envelope string
+	// Offset is the byte offset of the envelope in the input, or 0 when a single secret is decrypted
+	Offset int
+	// Kind is the sentinel error for the failure mode
+	Kind error
+	// Err is the underlying error, if any
+	Err error
+}
+
+func newSecretError(kind, err error) *SecretError {
+	return &SecretError{
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+func (e *SecretError) Error() string {
+
+	message := fmt.Sprintf("secret at offset %v", e.Offset)
+	if e.Kind != nil {
+		message += ": " + e.Kind.Error()
+	}
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+
+	return message
+}
+
+// Unwrap returns the kind and underlying error, so errors.Is and errors.As match both
+func (e *SecretError) Unwrap() []error {
+
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+
+	return errs
+}
+
+// newKeyProviderError returns the error for a key provider failing to supply a key
+func newKeyProviderError(err error) *SecretError {
+
+	if errors.Is(err, ErrKeyNotFound) {
+		return newSecretError(ErrUnknownKey, err)
+	}
+
+	return newSecretError(ErrKeyUnavailable, err)
+}
+
+// newKeyWrapperError returns the error for a key wrapper failing to wrap or unwrap a data key
+func newKeyWrapperError(err error) *SecretError {
+
+	if errors.Is(err, ErrTamperedSecret) {
+		return newSecretError(ErrTamperedSecret, err)
+	}
+
+	return newKeyProviderError(err)
+}
+
+// secretErrorAt returns a copy of the error located at the envelope and offset
+func secretErrorAt(err error, envelope string, offset int) error {
+
+	var secretErr *SecretError
+	if !errors.As(err, &secretErr) {
+		secretErr = newSecretError(nil, err)
+	}
+
+	located := *secretErr
+	located.Envelope = envelope
+	located.Offset = offset
+
+	return &located
+}
+
+// isDeniedSecretError returns whether the error is a secret being denied to the scope or at the time it's decrypted,
+// rather than a failure to decrypt it
+func isDeniedSecretError(err error) bool {
+	return errors.Is(err, ErrRestrictedSecret) || errors.Is(err, ErrSecretOutOfScope) || errors.Is(err, ErrSecretExpired) || errors.Is(err, ErrSecretNotYetValid)
+}
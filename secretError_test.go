@@ -0,0 +1,145 @@
+package crypt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretError(t *testing.T) {
+
+	t.Run("ReturnsErrMalformedSecretIfStringDoesNotContainDot", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce := "deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u"
+
+		// act
+		_, _, err := secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+		var secretErr *SecretError
+		if assert.True(t, errors.As(err, &secretErr)) {
+			assert.Equal(t, encryptedTextPlusNonce, secretErr.Envelope)
+			assert.Equal(t, 0, secretErr.Offset)
+			assert.Equal(t, ErrMalformedSecret, secretErr.Kind)
+		}
+	})
+
+	t.Run("ReturnsErrMalformedSecretIfStringIsNotBase64Encoded", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		// act
+		_, _, err := secretHelper.Decrypt("34TwMlihi18JCWHS.bC7Kc=qyjxJu0bLYnnhLwyXRc1FpBdgWL861orEETEl5d", "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+	})
+
+	t.Run("ReturnsErrMalformedSecretIfNonceHasWrongSize", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		// act
+		_, _, err := secretHelper.Decrypt("34TwMlih.bC7KcqyjxJu0bLYnnhLwyXRc1FpBdgWL861orEETEl5d", "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+	})
+
+	t.Run("ReturnsErrUnknownKeyIfKeyIDIsNotInKeyring", func(t *testing.T) {
+
+		encryptedTextPlusNonce, err := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false).Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		secretHelper := NewSecretHelper("7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+
+		// act
+		_, _, err = secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrUnknownKey))
+	})
+
+	t.Run("ReturnsErrWrongKeyIfLegacySecretCannotBeDecryptedWithAnyKey", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+
+		// act
+		_, _, err := secretHelper.Decrypt("34TwMlihi18JCWHS.bC7KcqyjxJu0bLYnnhLwyXRc1FpBdgWL861orEETEl5d", "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrWrongKey))
+		assert.False(t, errors.Is(err, ErrTamperedSecret))
+	})
+
+	t.Run("ReturnsErrTamperedSecretIfValueIsModified", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		splittedStrings := strings.Split(encryptedTextPlusNonce, ".")
+		otherEncryptedTextPlusNonce, err := secretHelper.Encrypt("this is my other secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		splittedStrings[3] = strings.Split(otherEncryptedTextPlusNonce, ".")[3]
+
+		// act
+		_, _, err = secretHelper.Decrypt(strings.Join(splittedStrings, "."), "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrTamperedSecret))
+	})
+
+	t.Run("ReturnsErrInvalidKeyIfKeyIsNotBase64Encoded", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("not base64 encoded", true)
+
+		// act
+		_, _, err := secretHelper.Decrypt("34TwMlihi18JCWHS.bC7KcqyjxJu0bLYnnhLwyXRc1FpBdgWL861orEETEl5d", "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrInvalidKey))
+	})
+
+	t.Run("ReturnsErrRestrictedSecretFromDecryptEnvelope", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextInEnvelope := "ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=)"
+
+		// act
+		_, _, err := secretHelper.DecryptEnvelope(encryptedTextInEnvelope, "github.com/ziplineeci/ziplinee-ci-web")
+
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
+		var secretErr *SecretError
+		if assert.True(t, errors.As(err, &secretErr)) {
+			assert.Equal(t, encryptedTextInEnvelope, secretErr.Envelope)
+		}
+	})
+
+	t.Run("ReturnsEnvelopeAndOffsetFromDecryptAllEnvelopes", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := `token: ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=)`
+
+		// act
+		_, err := secretHelper.DecryptAllEnvelopes(input, "github.com/ziplineeci/ziplinee-ci-web")
+
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
+		var secretErr *SecretError
+		if assert.True(t, errors.As(err, &secretErr)) {
+			assert.Equal(t, input[7:], secretErr.Envelope)
+			assert.Equal(t, 7, secretErr.Offset)
+		}
+	})
+
+	t.Run("ReturnsEnvelopeAndOffsetFromGetAllSecretValues", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := `token: ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P) ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u)`
+
+		// act
+		_, err := secretHelper.GetAllSecretValues(input, "github.com/ziplineeci/ziplinee-ci-web")
+
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+		var secretErr *SecretError
+		if assert.True(t, errors.As(err, &secretErr)) {
+			assert.Equal(t, "ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u)", secretErr.Envelope)
+			assert.Equal(t, strings.LastIndex(input, "ziplinee.secret("), secretErr.Offset)
+		}
+	})
+}
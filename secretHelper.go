@@ -1,207 +1,618 @@
 package crypt
 
 import (
-	"crypto/aes"
+	"context"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
-	"regexp"
 	"strings"
+	"time"
 )
 
 var (
 	// ErrRestrictedSecret is thrown if a restricted secret for another pipeline is encountered
 	ErrRestrictedSecret = errors.New("this secret is restricted to another pipeline")
+
+	// ErrSecretOutOfScope is thrown if a secret is restricted to other branches, events, stages or release targets than
+	// the ones of the scope it's decrypted for
+	ErrSecretOutOfScope = errors.New("this secret is restricted to another scope")
+
+	// ErrSecretExpired is thrown if a secret is decrypted after it expired
+	ErrSecretExpired = errors.New("this secret has expired")
+
+	// ErrSecretNotYetValid is thrown if a secret is decrypted before its not-before time
+	ErrSecretNotYetValid = errors.New("this secret isn't valid yet")
+
+	// ErrInvalidRestriction is thrown if a restriction of a secret other than its pipeline allow list is invalid
+	ErrInvalidRestriction = errors.New("the restrictions of this secret are invalid")
+
+	// ErrMalformedSecret is thrown if a secret doesn't split into the expected parts or isn't base64 encoded
+	ErrMalformedSecret = errors.New("this secret is malformed")
+
+	// ErrInvalidKey is thrown if the key isn't a valid AES key or can't be base64 decoded
+	ErrInvalidKey = errors.New("the key is invalid")
+
+	// ErrUnknownKey is thrown if a secret is encrypted with a key id the key provider doesn't have
+	ErrUnknownKey = errors.New("this secret is encrypted with an unknown key")
+
+	// ErrKeyUnavailable is thrown if the key provider fails to supply a key
+	ErrKeyUnavailable = errors.New("the key is unavailable")
+
+	// ErrWrongKey is thrown if none of the keys decrypts a secret that doesn't identify its key; for these legacy
+	// secrets tampering can't be told apart from a wrong key
+	ErrWrongKey = errors.New("this secret can't be decrypted with any of the keys")
+
+	// ErrTamperedSecret is thrown if a secret fails authentication with the key it identifies
+	ErrTamperedSecret = errors.New("this secret has been tampered with")
+
+	// ErrInvalidPipelineAllowList is thrown if the pipeline allow list of a secret can't be parsed
+	ErrInvalidPipelineAllowList = errors.New("the pipeline allow list of this secret is invalid")
 )
 
 // DefaultPipelineAllowList is the regular expression that allows any pipeline to decrypt a secret
 const DefaultPipelineAllowList = ".*"
 
+// gcmStandardNonceSize is the nonce size of cipher.NewGCM
+const gcmStandardNonceSize = 12
+
+// secretFormatV2 prefixes secrets in which every sealed field has its own nonce
+const secretFormatV2 = "v2"
+
+// secretFormatV2WrappedKey prefixes v2 secrets that are encrypted with their own data key, which is carried in the
+// secret wrapped by a master key
+const secretFormatV2WrappedKey = "v2w"
+
+// dataKeySize is the size of the data keys generated for secrets, to select AES-256
+const dataKeySize = 32
+
 // SecretEnvelopeRegex is the regular expression to match an ziplinee secret envelope
 const SecretEnvelopeRegex = `ziplinee\.secret\(([a-zA-Z0-9.=_-]+)\)`
 
 // SecretHelper is the interface for encrypting and decrypting secrets
 type SecretHelper interface {
-	Encrypt(unencryptedText, pipelineAllowList string) (encryptedTextPlusNonce string, err error)
+	Encrypt(unencryptedText, pipelineAllowList string, options ...EncryptOption) (encryptedTextPlusNonce string, err error)
 	Decrypt(encryptedTextPlusNonce, pipeline string) (decryptedText, pipelineAllowList string, err error)
-	EncryptEnvelope(unencryptedText, pipelineAllowList string) (encryptedTextInEnvelope string, err error)
+	EncryptEnvelope(unencryptedText, pipelineAllowList string, options ...EncryptOption) (encryptedTextInEnvelope string, err error)
 	DecryptEnvelope(encryptedTextInEnvelope, pipeline string) (decryptedText, pipelineAllowList string, err error)
-	DecryptAllEnvelopes(encryptedTextWithEnvelopes, pipeline string) (decryptedText string, err error)
-	ReencryptAllEnvelopes(encryptedTextWithEnvelopes, pipeline string, base64encodedKey bool) (reencryptedText string, key string, err error)
+	DecryptAllEnvelopes(encryptedTextWithEnvelopes, pipeline string, options ...BulkOption) (decryptedText string, err error)
+	ReencryptAllEnvelopes(encryptedTextWithEnvelopes, pipeline string, base64encodedKey bool, options ...BulkOption) (reencryptedText string, key string, err error)
+	ReencryptAllEnvelopesWithReport(encryptedTextWithEnvelopes, pipeline string, base64encodedKey bool, options ...BulkOption) (report ReencryptReport, err error)
+	RewrapAllEnvelopes(encryptedTextWithEnvelopes string, options ...BulkOption) (rewrappedText string, err error)
 	GenerateKey(numberOfBytes int, base64encodedKey bool) (key string, err error)
 	GetAllSecretEnvelopes(input string) (envelopes []string, err error)
 	GetAllSecrets(input string) (secrets []string, err error)
-	GetAllSecretValues(input, pipeline string) (values []string, err error)
+	GetAllSecretValues(input, pipeline string, options ...BulkOption) (values []string, err error)
 	GetInvalidRestrictedSecrets(input, pipeline string) (invalidSecrets []string, err error)
+	GetExpiringSecrets(input string, within time.Duration) (expiringSecrets []ExpiringSecret, err error)
 	IsEncryptedEnvelope(s string) bool
+	Fingerprint(encryptedTextPlusNonce string) (fingerprint string, err error)
+	FingerprintEnvelope(encryptedTextInEnvelope string) (fingerprint string, err error)
+	GetAllSecretFingerprints(input string) (fingerprints []SecretFingerprint, err error)
+	GetSharedSecrets(manifests map[string]string, options ...BulkOption) (sharedSecrets []SharedSecret, err error)
+
+	EncryptContext(ctx context.Context, unencryptedText, pipelineAllowList string, options ...EncryptOption) (encryptedTextPlusNonce string, err error)
+	EncryptEnvelopeContext(ctx context.Context, unencryptedText, pipelineAllowList string, options ...EncryptOption) (encryptedTextInEnvelope string, err error)
+	DecryptContext(ctx context.Context, encryptedTextPlusNonce string, scope Scope) (decryptedText, pipelineAllowList string, err error)
+	DecryptEnvelopeContext(ctx context.Context, encryptedTextInEnvelope string, scope Scope) (decryptedText, pipelineAllowList string, err error)
+	DecryptAllEnvelopesContext(ctx context.Context, encryptedTextWithEnvelopes string, scope Scope, options ...BulkOption) (decryptedText string, err error)
+	ReencryptAllEnvelopesContext(ctx context.Context, encryptedTextWithEnvelopes, pipeline string, base64encodedKey bool, options ...BulkOption) (reencryptedText string, key string, err error)
+	ReencryptAllEnvelopesWithReportContext(ctx context.Context, encryptedTextWithEnvelopes, pipeline string, base64encodedKey bool, options ...BulkOption) (report ReencryptReport, err error)
+	RewrapAllEnvelopesContext(ctx context.Context, encryptedTextWithEnvelopes string, options ...BulkOption) (rewrappedText string, err error)
+	GetAllSecretValuesContext(ctx context.Context, input string, scope Scope, options ...BulkOption) (values []string, err error)
+	GetExpiringSecretsContext(ctx context.Context, input string, within time.Duration) (expiringSecrets []ExpiringSecret, err error)
+	FingerprintContext(ctx context.Context, encryptedTextPlusNonce string) (fingerprint string, err error)
+	GetAllSecretFingerprintsContext(ctx context.Context, input string) (fingerprints []SecretFingerprint, err error)
+	GetSharedSecretsContext(ctx context.Context, manifests map[string]string, options ...BulkOption) (sharedSecrets []SharedSecret, err error)
+
+	DecryptAllEnvelopesStream(ctx context.Context, r io.Reader, w io.Writer, scope Scope, options ...BulkOption) (err error)
+	ReencryptAllEnvelopesStream(ctx context.Context, r io.Reader, w io.Writer, pipeline string, base64encodedKey bool, options ...BulkOption) (key string, err error)
+}
+
+// ReencryptReport describes the outcome of re-encrypting all envelopes in a text
+type ReencryptReport struct {
+	// ReencryptedText is the input with every envelope that could be re-encrypted replaced
+	ReencryptedText string
+	// Key is the new key the envelopes are re-encrypted with
+	Key string
+	// Envelopes has an entry for every envelope in the input, in order of appearance
+	Envelopes []ReencryptedEnvelope
+}
+
+// ReencryptedEnvelope describes the outcome of re-encrypting a single envelope
+type ReencryptedEnvelope struct {
+	// Offset is the byte offset of the envelope in the input
+	Offset int
+	// Envelope is the original envelope
+	Envelope string
+	// ReencryptedEnvelope is the envelope encrypted with the new key, or empty if re-encryption failed
+	ReencryptedEnvelope string
+	// PipelineAllowList is the pipeline allow list carried over to the re-encrypted envelope
+	PipelineAllowList string
+	// Err is the reason the envelope couldn't be re-encrypted, if any
+	Err error
+}
+
+// ExpiringSecret describes a secret that expires
+type ExpiringSecret struct {
+	// Offset is the byte offset of the envelope in the input
+	Offset int
+	// Envelope is the secret in its envelope
+	Envelope string
+	// ExpiresAt is the time the secret expires, which can be in the past
+	ExpiresAt time.Time
 }
 
 type secretHelperImpl struct {
-	key              string
-	base64encodedKey bool
+	keyProvider         KeyProvider
+	keyWrapper          KeyWrapper
+	encryptWithDataKeys bool
+	repositoryMoves     []RepositoryMove
+	now                 func() time.Time
+	aeads               aeadCache
+	allowLists          allowListCache
+	observer            AuditObserver
+	fingerprintKey      []byte
+	err                 error
 }
 
 // NewSecretHelper returns a new SecretHelper
-func NewSecretHelper(key string, base64encodedKey bool) SecretHelper {
+func NewSecretHelper(key string, base64encodedKey bool, options ...SecretHelperOption) SecretHelper {
 
-	return &secretHelperImpl{
-		key:              key,
-		base64encodedKey: base64encodedKey,
-	}
+	keyring := NewKeyring()
+	_, err := keyring.AddKey("", key, base64encodedKey)
+
+	return applySecretHelperOptions(&secretHelperImpl{
+		keyProvider: keyring,
+		keyWrapper:  NewKeyProviderKeyWrapper(keyring),
+		err:         err,
+	}, options)
 }
 
-func (sh *secretHelperImpl) getKey(key string, base64encodedKey bool) (keyBytes []byte, err error) {
+// NewSecretHelperWithKeyring returns a new SecretHelper that encrypts with the active key of the keyring and decrypts
+// with any of its keys
+func NewSecretHelperWithKeyring(keyring *Keyring, options ...SecretHelperOption) SecretHelper {
+	return NewSecretHelperWithKeyProvider(keyring, options...)
+}
 
-	keyBytes = []byte(key)
-	if base64encodedKey {
-		keyBytes, err = base64.StdEncoding.DecodeString(key)
-		if err != nil {
-			return keyBytes, err
-		}
-	}
+// NewSecretHelperWithKeyProvider returns a new SecretHelper that consults the key provider for its keys on every use
+func NewSecretHelperWithKeyProvider(keyProvider KeyProvider, options ...SecretHelperOption) SecretHelper {
+
+	return applySecretHelperOptions(&secretHelperImpl{
+		keyProvider: keyProvider,
+		keyWrapper:  NewKeyProviderKeyWrapper(keyProvider),
+	}, options)
+}
 
-	return keyBytes, nil
+// NewSecretHelperWithKeyWrapper returns a new SecretHelper that encrypts every secret with a random data key and
+// carries that data key in the secret wrapped by the key wrapper; it only decrypts secrets encrypted this way
+func NewSecretHelperWithKeyWrapper(keyWrapper KeyWrapper, options ...SecretHelperOption) SecretHelper {
+
+	return applySecretHelperOptions(&secretHelperImpl{
+		keyWrapper:          keyWrapper,
+		encryptWithDataKeys: true,
+	}, options)
 }
 
 func (sh *secretHelperImpl) IsEncryptedEnvelope(s string) bool {
-	r, err := regexp.Compile(fmt.Sprintf("^%v$", SecretEnvelopeRegex))
+	return IsEncryptedEnvelope(s)
+}
+
+// IsEncryptedEnvelope returns whether s is a single secret in its envelope; it needs no key
+func IsEncryptedEnvelope(s string) bool {
+	_, ok := unwrapEnvelope(s)
+	return ok
+}
+
+func (sh *secretHelperImpl) Encrypt(unencryptedText, pipelineAllowList string, options ...EncryptOption) (encryptedTextPlusNonce string, err error) {
+	return sh.EncryptContext(context.Background(), unencryptedText, pipelineAllowList, options...)
+}
+
+func (sh *secretHelperImpl) EncryptContext(ctx context.Context, unencryptedText, pipelineAllowList string, options ...EncryptOption) (encryptedTextPlusNonce string, err error) {
+
+	if sh.err != nil {
+		return "", sh.err
+	}
+
+	// validate the restrictions before they're sealed, so a malformed one never ends up in a secret
+	restrictions, err := newSecretRestrictions(pipelineAllowList, options)
 	if err != nil {
-		return false
+		return
 	}
-	return r.MatchString(s)
+
+	encryptedTextPlusNonce, err = sh.encrypt(ctx, unencryptedText, restrictions)
+	sh.observe(ctx, AuditOperationEncrypt, encryptedTextPlusNonce, sh.fingerprint(unencryptedText), Scope{}, restrictions.PipelineAllowList, err)
+
+	return
 }
 
-func (sh *secretHelperImpl) Encrypt(unencryptedText, pipelineAllowList string) (encryptedTextPlusNonce string, err error) {
-	return sh.encryptWithKey(unencryptedText, pipelineAllowList, sh.key, sh.base64encodedKey)
+func (sh *secretHelperImpl) encrypt(ctx context.Context, unencryptedText string, restrictions secretRestrictions) (encryptedTextPlusNonce string, err error) {
+
+	if sh.encryptWithDataKeys {
+		return sh.encryptWithDataKey(ctx, unencryptedText, restrictions)
+	}
+
+	keyID, keyBytes, err := sh.keyProvider.ActiveKey(ctx)
+	if err != nil {
+		return
+	}
+
+	return sh.encryptWithKey(unencryptedText, restrictions, keyID, keyBytes)
 }
 
-func (sh *secretHelperImpl) encryptWithKey(unencryptedText, pipelineAllowList, key string, base64encodedKey bool) (encryptedTextPlusNonce string, err error) {
+func (sh *secretHelperImpl) encryptWithKey(unencryptedText string, restrictions secretRestrictions, keyID string, keyBytes []byte) (encryptedTextPlusNonce string, err error) {
 
-	// The key argument should be the AES key, either 16 or 32 bytes to select AES-128 or AES-256.
-	keyBytes, err := sh.getKey(key, base64encodedKey)
+	aesgcm, err := sh.aeads.get(keyBytes)
 	if err != nil {
 		return
 	}
-	plaintext := []byte(unencryptedText)
 
-	block, err := aes.NewCipher(keyBytes)
+	sealedFields, err := sh.sealFields(unencryptedText, restrictions, aesgcm)
 	if err != nil {
 		return
 	}
 
-	// Never use more than 2^32 random nonces with a given key because of the risk of a repeat.
-	nonce := make([]byte, 12)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return encryptedTextPlusNonce, err
+	return fmt.Sprintf("%v.%v.%v", secretFormatV2, keyID, sealedFields), nil
+}
+
+func (sh *secretHelperImpl) encryptWithDataKey(ctx context.Context, unencryptedText string, restrictions secretRestrictions) (encryptedTextPlusNonce string, err error) {
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err = io.ReadFull(rand.Reader, dataKey); err != nil {
+		return
+	}
+
+	masterKeyID, wrappedKey, err := sh.wrapKey(ctx, dataKey)
+	if err != nil {
+		return
 	}
 
-	aesgcm, err := cipher.NewGCM(block)
+	aesgcm, err := newAEAD(dataKey)
 	if err != nil {
 		return
 	}
 
-	ciphertext := aesgcm.Seal(nil, nonce, plaintext, nil)
+	sealedFields, err := sh.sealFields(unencryptedText, restrictions, aesgcm)
+	if err != nil {
+		return
+	}
+
+	return fmt.Sprintf("%v.%v.%v.%v", secretFormatV2WrappedKey, masterKeyID, base64.URLEncoding.EncodeToString(wrappedKey), sealedFields), nil
+}
 
-	encryptedTextPlusNonce = fmt.Sprintf("%v.%v", base64.URLEncoding.EncodeToString(nonce), base64.URLEncoding.EncodeToString(ciphertext))
+func (sh *secretHelperImpl) wrapKey(ctx context.Context, dataKey []byte) (masterKeyID string, wrappedKey []byte, err error) {
 
-	pipelineAllowList = strings.TrimSpace(pipelineAllowList)
-	if pipelineAllowList != "" && pipelineAllowList != DefaultPipelineAllowList {
-		cipherpipelinewhitelist := aesgcm.Seal(nil, nonce, []byte(pipelineAllowList), nil)
-		encryptedTextPlusNonce += fmt.Sprintf(".%v", base64.URLEncoding.EncodeToString(cipherpipelinewhitelist))
+	masterKeyID, wrappedKey, err = sh.keyWrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		return
+	}
+	if !keyIDRegex.MatchString(masterKeyID) {
+		return "", nil, fmt.Errorf("master key id %q contains characters other than letters, digits, '-' and '_'", masterKeyID)
 	}
 
 	return
 }
 
-func (sh *secretHelperImpl) Decrypt(encryptedTextPlusNonce, pipeline string) (decryptedText, pipelineAllowList string, err error) {
-	return sh.decrypt(encryptedTextPlusNonce, pipeline, true)
+// sealFields returns the nonce and value and, for a restricted secret, the nonce and restrictions of a v2 secret
+func (sh *secretHelperImpl) sealFields(unencryptedText string, restrictions secretRestrictions, aesgcm cipher.AEAD) (sealedFields string, err error) {
+
+	sealedRestrictions, err := restrictions.encode()
+	if err != nil {
+		return
+	}
+
+	// every sealed field gets its own nonce, because reusing a nonce under the same key breaks gcm; the restrictions
+	// are authenticated as additional data of the value so they can't be stripped or swapped
+	nonce, ciphertext, err := seal(aesgcm, []byte(unencryptedText), []byte(sealedRestrictions))
+	if err != nil {
+		return
+	}
+
+	sealedFields = fmt.Sprintf("%v.%v", base64.URLEncoding.EncodeToString(nonce), base64.URLEncoding.EncodeToString(ciphertext))
+
+	if sealedRestrictions != DefaultPipelineAllowList {
+		restrictionsNonce, cipherrestrictions, err := seal(aesgcm, []byte(sealedRestrictions), nil)
+		if err != nil {
+			return "", err
+		}
+		sealedFields += fmt.Sprintf(".%v.%v", base64.URLEncoding.EncodeToString(restrictionsNonce), base64.URLEncoding.EncodeToString(cipherrestrictions))
+	}
+
+	return
+}
+
+// seal seals plaintext with a random nonce of its own
+func seal(aesgcm cipher.AEAD, plaintext, additionalData []byte) (nonce, ciphertext []byte, err error) {
+
+	// Never use more than 2^32 random nonces with a given key because of the risk of a repeat.
+	nonce = make([]byte, aesgcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+
+	ciphertext = aesgcm.Seal(nil, nonce, plaintext, additionalData)
+
+	return
 }
 
-func (sh *secretHelperImpl) decrypt(encryptedTextPlusNonce, pipeline string, failOnRestrictError bool) (decryptedText, pipelineAllowList string, err error) {
-	return sh.decryptWithKey(encryptedTextPlusNonce, pipeline, sh.key, sh.base64encodedKey, failOnRestrictError)
+func (sh *secretHelperImpl) Decrypt(encryptedTextPlusNonce, pipeline string) (decryptedText, pipelineAllowList string, err error) {
+	return sh.DecryptContext(context.Background(), encryptedTextPlusNonce, pipelineScope(pipeline))
 }
 
-func (sh *secretHelperImpl) decryptWithKey(encryptedTextPlusNonce, pipeline string, key string, base64encodedKey, failOnRestrictError bool) (decryptedText, pipelineAllowList string, err error) {
+func (sh *secretHelperImpl) DecryptContext(ctx context.Context, encryptedTextPlusNonce string, scope Scope) (decryptedText, pipelineAllowList string, err error) {
 
-	// get decryption key
-	keyBytes, err := sh.getKey(key, base64encodedKey)
+	decryptedText, restrictions, err := sh.decrypt(ctx, AuditOperationDecrypt, encryptedTextPlusNonce, scope, true)
 	if err != nil {
-		return
+		return "", "", secretErrorAt(err, encryptedTextPlusNonce, 0)
+	}
+
+	return decryptedText, restrictions.PipelineAllowList, nil
+}
+
+func (sh *secretHelperImpl) decrypt(ctx context.Context, operation AuditOperation, encryptedTextPlusNonce string, scope Scope, failOnRestrictError bool) (decryptedText string, restrictions secretRestrictions, err error) {
+
+	decryptedText, restrictions, err = sh.decryptSecret(ctx, encryptedTextPlusNonce, scope, failOnRestrictError)
+	sh.observe(ctx, operation, encryptedTextPlusNonce, sh.fingerprint(decryptedText), scope, restrictions.PipelineAllowList, err)
+
+	return
+}
+
+func (sh *secretHelperImpl) decryptSecret(ctx context.Context, encryptedTextPlusNonce string, scope Scope, failOnRestrictError bool) (decryptedText string, restrictions secretRestrictions, err error) {
+	return sh.openSecret(ctx, encryptedTextPlusNonce, func(secret encryptedSecret, aesgcm cipher.AEAD) (string, secretRestrictions, error) {
+		return sh.decryptWithKey(secret, scope, aesgcm, failOnRestrictError)
+	})
+}
+
+// inspectRestrictions opens the restrictions of the secret without ever opening its value
+func (sh *secretHelperImpl) inspectRestrictions(ctx context.Context, encryptedTextPlusNonce string) (restrictions secretRestrictions, err error) {
+
+	_, restrictions, err = sh.openSecret(ctx, encryptedTextPlusNonce, func(secret encryptedSecret, aesgcm cipher.AEAD) (string, secretRestrictions, error) {
+		_, restrictions, err := openRestrictions(secret, aesgcm)
+		return "", restrictions, err
+	})
+	sh.observe(ctx, AuditOperationInspect, encryptedTextPlusNonce, "", Scope{}, restrictions.PipelineAllowList, err)
+
+	return
+}
+
+// openSecret looks up the key of the secret and opens the secret with it using open; legacy secrets, which don't
+// identify their key, are opened with every key in turn
+func (sh *secretHelperImpl) openSecret(ctx context.Context, encryptedTextPlusNonce string, open func(secret encryptedSecret, aesgcm cipher.AEAD) (string, secretRestrictions, error)) (decryptedText string, restrictions secretRestrictions, err error) {
+
+	if sh.err != nil {
+		return "", restrictions, newSecretError(ErrInvalidKey, sh.err)
 	}
-	block, err := aes.NewCipher(keyBytes)
+
+	secret, err := parseEncryptedSecret(encryptedTextPlusNonce)
 	if err != nil {
 		return
 	}
-	aesgcm, err := cipher.NewGCM(block)
+
+	if secret.wrappedKey != nil {
+		dataKey, err := sh.keyWrapper.UnwrapKey(ctx, secret.keyID, secret.wrappedKey)
+		if err != nil {
+			return "", restrictions, newKeyWrapperError(err)
+		}
+		aesgcm, err := newAEAD(dataKey)
+		if err != nil {
+			return "", restrictions, newSecretError(ErrInvalidKey, err)
+		}
+		return open(secret, aesgcm)
+	}
+
+	if sh.keyProvider == nil {
+		return "", restrictions, newSecretError(ErrUnknownKey, errors.New("this secret isn't encrypted with a data key"))
+	}
+
+	if secret.keyID != "" {
+		keyBytes, err := sh.keyProvider.Key(ctx, secret.keyID)
+		if err != nil {
+			return "", restrictions, newKeyProviderError(err)
+		}
+		aesgcm, err := sh.aeads.get(keyBytes)
+		if err != nil {
+			return "", restrictions, newSecretError(ErrInvalidKey, err)
+		}
+		return open(secret, aesgcm)
+	}
+
+	// legacy secrets don't identify the key they're encrypted with, so try the active key first and the retired keys after
+	keys, err := sh.keyProvider.Keys(ctx)
 	if err != nil {
-		return
+		return "", restrictions, newKeyProviderError(err)
+	}
+	err = newSecretError(ErrUnknownKey, errors.New("there are no keys"))
+	for _, keyBytes := range keys {
+		aesgcm, innerErr := sh.aeads.get(keyBytes)
+		if innerErr != nil {
+			return "", restrictions, newSecretError(ErrInvalidKey, innerErr)
+		}
+		decryptedText, restrictions, err = open(secret, aesgcm)
+		if err == nil || !errors.Is(err, ErrTamperedSecret) {
+			return
+		}
 	}
+	var secretErr *SecretError
+	if errors.As(err, &secretErr) && secretErr.Kind == ErrTamperedSecret {
+		err = newSecretError(ErrWrongKey, secretErr.Err)
+	}
+
+	return "", restrictions, err
+}
+
+// encryptedSecret holds the decoded fields of an encrypted secret
+type encryptedSecret struct {
+	keyID                     string
+	wrappedKey                []byte
+	valueNonce                []byte
+	valueEncrypted            []byte
+	restrictionsNonce         []byte
+	restrictionsEncrypted     []byte
+	restrictionsAuthenticated bool
+}
+
+func parseEncryptedSecret(encryptedTextPlusNonce string) (secret encryptedSecret, err error) {
 
 	// split string on dots to get nonce, value and pipeline whitelist
 	splittedStrings := strings.Split(encryptedTextPlusNonce, ".")
-	if splittedStrings == nil || (len(splittedStrings) != 2 && len(splittedStrings) != 3) {
-		err = errors.New("The encrypted text plus nonce doesn't split correctly")
-		return
+
+	decode := func(s string) []byte {
+		decoded, decodeErr := base64.URLEncoding.DecodeString(s)
+		if decodeErr != nil && err == nil {
+			err = newSecretError(ErrMalformedSecret, decodeErr)
+		}
+		return decoded
 	}
 
-	// get nonce
-	nonceBase64 := splittedStrings[0]
-	nonce, _ := base64.URLEncoding.DecodeString(nonceBase64)
+	switch {
+	case splittedStrings[0] == secretFormatV2 && (len(splittedStrings) == 4 || len(splittedStrings) == 6):
+		// v2.keyid.nonce.value[.nonce.restrictions], every field sealed with its own nonce and the restrictions
+		// authenticated as additional data of the value
+		secret.keyID = splittedStrings[1]
+		secret.restrictionsAuthenticated = true
+		secret.valueNonce = decode(splittedStrings[2])
+		secret.valueEncrypted = decode(splittedStrings[3])
+		if len(splittedStrings) == 6 {
+			secret.restrictionsNonce = decode(splittedStrings[4])
+			secret.restrictionsEncrypted = decode(splittedStrings[5])
+		}
 
-	// get pipeline whitelist if present
-	pipelineAllowList = DefaultPipelineAllowList
-	if len(splittedStrings) == 3 {
-		pipelineAllowListBase64 := splittedStrings[2]
-		pipelineAllowListEncrypted, _ := base64.URLEncoding.DecodeString(pipelineAllowListBase64)
-		pipelineAllowListBytes, err := aesgcm.Open(nil, nonce, pipelineAllowListEncrypted, nil)
-		if err != nil {
-			return "", "", err
+	case splittedStrings[0] == secretFormatV2WrappedKey && (len(splittedStrings) == 5 || len(splittedStrings) == 7):
+		// v2w.masterkeyid.wrappedkey.nonce.value[.nonce.restrictions], a v2 secret sealed with the data key that
+		// is wrapped by the master key
+		secret.keyID = splittedStrings[1]
+		secret.wrappedKey = decode(splittedStrings[2])
+		secret.restrictionsAuthenticated = true
+		secret.valueNonce = decode(splittedStrings[3])
+		secret.valueEncrypted = decode(splittedStrings[4])
+		if len(splittedStrings) == 7 {
+			secret.restrictionsNonce = decode(splittedStrings[5])
+			secret.restrictionsEncrypted = decode(splittedStrings[6])
+		}
+
+	case splittedStrings[0] != secretFormatV2 && splittedStrings[0] != secretFormatV2WrappedKey && (len(splittedStrings) == 2 || len(splittedStrings) == 3):
+		// legacy nonce.value[.pipelineallowlist], all fields sealed with the same nonce
+		secret.valueNonce = decode(splittedStrings[0])
+		secret.valueEncrypted = decode(splittedStrings[1])
+		if len(splittedStrings) == 3 {
+			secret.restrictionsNonce = secret.valueNonce
+			secret.restrictionsEncrypted = decode(splittedStrings[2])
 		}
-		pipelineAllowList = string(pipelineAllowListBytes)
+
+	default:
+		err = newSecretError(ErrMalformedSecret, errors.New("The encrypted text plus nonce doesn't split correctly"))
 	}
 
-	// check if pipeline is matched by pipeline whitelist regular expression
+	// gcm panics on nonces of the wrong size
+	if err == nil && (len(secret.valueNonce) != gcmStandardNonceSize || (secret.restrictionsEncrypted != nil && len(secret.restrictionsNonce) != gcmStandardNonceSize)) {
+		err = newSecretError(ErrMalformedSecret, errors.New("The nonce has the wrong size"))
+	}
+
+	return
+}
+
+func (sh *secretHelperImpl) decryptWithKey(secret encryptedSecret, scope Scope, aesgcm cipher.AEAD, failOnRestrictError bool) (decryptedText string, restrictions secretRestrictions, err error) {
+
+	sealedRestrictions, restrictions, err := openRestrictions(secret, aesgcm)
+	if err != nil {
+		return "", restrictions, err
+	}
+
+	// check if the scope is allowed by the restrictions
 	if failOnRestrictError {
-		pattern := fmt.Sprintf("^%v$", pipelineAllowList)
-		validForPipeline, innerErr := regexp.MatchString(pattern, pipeline)
+		validForPipeline, innerErr := sh.allowsPipeline(secret, restrictions.PipelineAllowList, scope.Pipeline)
 		if innerErr != nil {
-			return "", "", innerErr
+			return "", restrictions, newSecretError(ErrInvalidPipelineAllowList, innerErr)
 		}
 		if !validForPipeline {
-			pattern = fmt.Sprintf("^github.com/.*/%s$", strings.Split(pipelineAllowList, "/")[2])
-			validForPipeline, innerErr = regexp.MatchString(pattern, pipeline)
-			if innerErr != nil {
-				return "", "", innerErr
-			}
-			if !validForPipeline {
-				return "", "", ErrRestrictedSecret
-			}
+			return "", restrictions, newSecretError(ErrRestrictedSecret, nil)
+		}
+		if innerErr := restrictions.checkScope(scope); innerErr != nil {
+			return "", restrictions, newSecretError(ErrSecretOutOfScope, innerErr)
+		}
+		if innerErr := restrictions.checkValidity(sh.now()); innerErr != nil {
+			return "", restrictions, innerErr
 		}
 	}
 
 	// get value
-	valueBase64 := splittedStrings[1]
-	valueEncrypted, _ := base64.URLEncoding.DecodeString(valueBase64)
-	valueBytes, err := aesgcm.Open(nil, nonce, valueEncrypted, nil)
+	var additionalData []byte
+	if secret.restrictionsAuthenticated {
+		additionalData = []byte(sealedRestrictions)
+	}
+	valueBytes, err := aesgcm.Open(nil, secret.valueNonce, secret.valueEncrypted, additionalData)
 	if err != nil {
-		return
+		return "", restrictions, newSecretError(ErrTamperedSecret, err)
 	}
 	decryptedText = string(valueBytes)
 
 	return
 }
 
-func (sh *secretHelperImpl) EncryptEnvelope(unencryptedText, pipelineAllowList string) (encryptedTextInEnvelope string, err error) {
+// openRestrictions returns the restrictions of the secret as they're sealed and parsed; for legacy secrets these are
+// just the pipeline allow list
+func openRestrictions(secret encryptedSecret, aesgcm cipher.AEAD) (sealedRestrictions string, restrictions secretRestrictions, err error) {
+
+	sealedRestrictions = DefaultPipelineAllowList
+	if secret.restrictionsEncrypted != nil {
+		sealedRestrictionsBytes, err := aesgcm.Open(nil, secret.restrictionsNonce, secret.restrictionsEncrypted, nil)
+		if err != nil {
+			return "", restrictions, newSecretError(ErrTamperedSecret, err)
+		}
+		sealedRestrictions = string(sealedRestrictionsBytes)
+	}
+	restrictions = secretRestrictions{PipelineAllowList: sealedRestrictions}
+	if secret.restrictionsAuthenticated {
+		restrictions, err = parseSecretRestrictions(sealedRestrictions)
+		if err != nil {
+			return "", restrictions, newSecretError(ErrInvalidRestriction, err)
+		}
+	}
+
+	return
+}
+
+// allowsPipeline returns whether the pipeline allow list, which is a regular expression for legacy secrets, allows the
+// pipeline or any pipeline it's declared to have moved from
+func (sh *secretHelperImpl) allowsPipeline(secret encryptedSecret, pipelineAllowList, pipeline string) (bool, error) {
+
+	allows, err := sh.allowLists.matcher(pipelineAllowList, !secret.restrictionsAuthenticated)
+	if err != nil {
+		return false, err
+	}
+
+	// follow the repository moves back from the pipeline, visiting every pipeline once so cycles end
+	visited := map[string]bool{}
+	pending := []string{pipeline}
+	for len(pending) > 0 {
+		pipeline, pending = pending[len(pending)-1], pending[:len(pending)-1]
+		if visited[pipeline] {
+			continue
+		}
+		visited[pipeline] = true
+
+		if allows(pipeline) {
+			return true, nil
+		}
+		for _, move := range sh.repositoryMoves {
+			if move.To == pipeline {
+				pending = append(pending, move.From)
+			}
+		}
+	}
+
+	return false, nil
+}
 
-	return sh.encryptEnvelopeWithKey(unencryptedText, pipelineAllowList, sh.key, sh.base64encodedKey)
+func (sh *secretHelperImpl) EncryptEnvelope(unencryptedText, pipelineAllowList string, options ...EncryptOption) (encryptedTextInEnvelope string, err error) {
+	return sh.EncryptEnvelopeContext(context.Background(), unencryptedText, pipelineAllowList, options...)
 }
 
-func (sh *secretHelperImpl) encryptEnvelopeWithKey(unencryptedText, pipelineAllowList, key string, base64encodedKey bool) (encryptedTextInEnvelope string, err error) {
+func (sh *secretHelperImpl) EncryptEnvelopeContext(ctx context.Context, unencryptedText, pipelineAllowList string, options ...EncryptOption) (encryptedTextInEnvelope string, err error) {
 
-	encryptedText, err := sh.encryptWithKey(unencryptedText, pipelineAllowList, key, base64encodedKey)
+	encryptedText, err := sh.EncryptContext(ctx, unencryptedText, pipelineAllowList, options...)
 	if err != nil {
 		return
 	}
@@ -211,22 +622,27 @@ func (sh *secretHelperImpl) encryptEnvelopeWithKey(unencryptedText, pipelineAllo
 }
 
 func (sh *secretHelperImpl) DecryptEnvelope(encryptedTextInEnvelope, pipeline string) (decryptedText, pipelineAllowList string, err error) {
-	return sh.decryptEnvelope(encryptedTextInEnvelope, pipeline, true)
+	return sh.DecryptEnvelopeContext(context.Background(), encryptedTextInEnvelope, pipelineScope(pipeline))
 }
 
-func (sh *secretHelperImpl) decryptEnvelope(encryptedTextInEnvelope, pipeline string, failOnRestrictError bool) (decryptedText, pipelineAllowList string, err error) {
+func (sh *secretHelperImpl) DecryptEnvelopeContext(ctx context.Context, encryptedTextInEnvelope string, scope Scope) (decryptedText, pipelineAllowList string, err error) {
 
-	r, err := regexp.Compile(fmt.Sprintf("^%v$", SecretEnvelopeRegex))
+	decryptedText, restrictions, err := sh.decryptEnvelope(ctx, AuditOperationDecrypt, encryptedTextInEnvelope, scope, true)
 	if err != nil {
-		return
+		return "", "", secretErrorAt(err, encryptedTextInEnvelope, 0)
 	}
 
-	matches := r.FindStringSubmatch(encryptedTextInEnvelope)
-	if matches == nil {
-		return encryptedTextInEnvelope, DefaultPipelineAllowList, nil
+	return decryptedText, restrictions.PipelineAllowList, nil
+}
+
+func (sh *secretHelperImpl) decryptEnvelope(ctx context.Context, operation AuditOperation, encryptedTextInEnvelope string, scope Scope, failOnRestrictError bool) (decryptedText string, restrictions secretRestrictions, err error) {
+
+	encryptedTextPlusNonce, ok := unwrapEnvelope(encryptedTextInEnvelope)
+	if !ok {
+		return encryptedTextInEnvelope, secretRestrictions{PipelineAllowList: DefaultPipelineAllowList}, nil
 	}
 
-	decryptedText, pipelineAllowList, err = sh.decrypt(matches[1], pipeline, failOnRestrictError)
+	decryptedText, restrictions, err = sh.decrypt(ctx, operation, encryptedTextPlusNonce, scope, failOnRestrictError)
 	if err != nil {
 		return
 	}
@@ -234,39 +650,66 @@ func (sh *secretHelperImpl) decryptEnvelope(encryptedTextInEnvelope, pipeline st
 	return
 }
 
-func (sh *secretHelperImpl) decryptEnvelopeInBytes(encryptedTextInEnvelope []byte, pipeline string) ([]byte, error) {
+func (sh *secretHelperImpl) DecryptAllEnvelopes(encryptedTextWithEnvelopes, pipeline string, options ...BulkOption) (decryptedText string, err error) {
+	return sh.DecryptAllEnvelopesContext(context.Background(), encryptedTextWithEnvelopes, pipelineScope(pipeline), options...)
+}
+
+func (sh *secretHelperImpl) DecryptAllEnvelopesContext(ctx context.Context, encryptedTextWithEnvelopes string, scope Scope, options ...BulkOption) (decryptedText string, err error) {
+
+	o := newBulkOptions(options)
 
-	decryptedText, _, err := sh.DecryptEnvelope(string(encryptedTextInEnvelope), pipeline)
+	decryptedText, errs, err := replaceAllEnvelopes(ctx, encryptedTextWithEnvelopes, o.workers, func(encryptedTextInEnvelope string, offset int) (string, error) {
+		return sh.bulkDecryptEnvelope(ctx, encryptedTextInEnvelope, offset, scope, o)
+	})
 	if err != nil {
-		return nil, err
+		return
 	}
 
-	return []byte(decryptedText), nil
+	return decryptedText, bulkDecryptError(o, errs)
 }
 
-func (sh *secretHelperImpl) DecryptAllEnvelopes(encryptedTextWithEnvelopes, pipeline string) (decryptedText string, err error) {
+// bulkDecryptEnvelope returns what replaces an envelope when decrypting every envelope in a text, and its error located
+// at its offset if it fails to decrypt
+func (sh *secretHelperImpl) bulkDecryptEnvelope(ctx context.Context, encryptedTextInEnvelope string, offset int, scope Scope, o *bulkOptions) (string, error) {
 
-	r, err := regexp.Compile(SecretEnvelopeRegex)
+	decryptedText, _, err := sh.decryptEnvelope(ctx, AuditOperationDecrypt, encryptedTextInEnvelope, scope, true)
 	if err != nil {
-		return
+		if o.keepUndecryptableEnvelopes {
+			return encryptedTextInEnvelope, secretErrorAt(err, encryptedTextInEnvelope, offset)
+		}
+		return "", secretErrorAt(err, encryptedTextInEnvelope, offset)
 	}
 
-	var decryptErr error
-	decryptedText = string(r.ReplaceAllFunc([]byte(encryptedTextWithEnvelopes), func(in []byte) []byte {
-		bytes, innerErr := sh.decryptEnvelopeInBytes(in, pipeline)
-		if innerErr != nil {
-			decryptErr = innerErr
+	return decryptedText, nil
+}
+
+// bulkDecryptError returns the error for the envelopes that failed to decrypt, which is the last one unless all errors
+// are collected
+func bulkDecryptError(o *bulkOptions, errs []error) error {
+
+	var decryptErrs []error
+	for _, err := range errs {
+		if err != nil {
+			decryptErrs = append(decryptErrs, err)
 		}
-		return bytes
-	}))
-	if decryptErr != nil {
-		return decryptedText, decryptErr
 	}
 
-	return
+	if len(decryptErrs) == 0 {
+		return nil
+	}
+	if o.collectAllErrors {
+		return errors.Join(decryptErrs...)
+	}
+
+	return decryptErrs[len(decryptErrs)-1]
 }
 
 func (sh *secretHelperImpl) GenerateKey(numberOfBytes int, base64encodedKey bool) (string, error) {
+	return GenerateKey(numberOfBytes, base64encodedKey)
+}
+
+// GenerateKey returns a random key of numberOfBytes, base64 encoded if base64encodedKey is set; it needs no key
+func GenerateKey(numberOfBytes int, base64encodedKey bool) (string, error) {
 
 	key := make([]byte, numberOfBytes)
 
@@ -283,115 +726,296 @@ func (sh *secretHelperImpl) GenerateKey(numberOfBytes int, base64encodedKey bool
 	return keyString, nil
 }
 
-func (sh *secretHelperImpl) ReencryptAllEnvelopes(encryptedTextWithEnvelopes, pipeline string, base64encodedKey bool) (reencryptedText string, key string, err error) {
+func (sh *secretHelperImpl) ReencryptAllEnvelopes(encryptedTextWithEnvelopes, pipeline string, base64encodedKey bool, options ...BulkOption) (reencryptedText string, key string, err error) {
+	return sh.ReencryptAllEnvelopesContext(context.Background(), encryptedTextWithEnvelopes, pipeline, base64encodedKey, options...)
+}
+
+func (sh *secretHelperImpl) ReencryptAllEnvelopesContext(ctx context.Context, encryptedTextWithEnvelopes, pipeline string, base64encodedKey bool, options ...BulkOption) (reencryptedText string, key string, err error) {
+
+	report, err := sh.ReencryptAllEnvelopesWithReportContext(ctx, encryptedTextWithEnvelopes, pipeline, base64encodedKey, options...)
+
+	return report.ReencryptedText, report.Key, err
+}
+
+func (sh *secretHelperImpl) ReencryptAllEnvelopesWithReport(encryptedTextWithEnvelopes, pipeline string, base64encodedKey bool, options ...BulkOption) (report ReencryptReport, err error) {
+	return sh.ReencryptAllEnvelopesWithReportContext(context.Background(), encryptedTextWithEnvelopes, pipeline, base64encodedKey, options...)
+}
+
+func (sh *secretHelperImpl) ReencryptAllEnvelopesWithReportContext(ctx context.Context, encryptedTextWithEnvelopes, pipeline string, base64encodedKey bool, options ...BulkOption) (report ReencryptReport, err error) {
 
-	// generate 32 bytes key
-	key, err = sh.GenerateKey(32, base64encodedKey)
+	o := newBulkOptions(options)
+
+	encryptEnvelope, key, err := sh.getReencryptTarget(ctx, o, base64encodedKey)
 	if err != nil {
-		return encryptedTextWithEnvelopes, key, err
+		return ReencryptReport{ReencryptedText: encryptedTextWithEnvelopes}, err
 	}
 
-	// scan for all secrets and replace them with new secret
-	r, err := regexp.Compile(SecretEnvelopeRegex)
+	// scan for all secrets and replace them with new secret, leaving the ones that fail untouched so they're never lost
+	reencryptedText, envelopes, err := replaceAllEnvelopes(ctx, encryptedTextWithEnvelopes, o.workers, func(encryptedTextInEnvelope string, offset int) (string, ReencryptedEnvelope) {
+		envelope := sh.reencryptEnvelope(ctx, encryptedTextInEnvelope, offset, pipeline, encryptEnvelope)
+		if envelope.Err != nil {
+			return encryptedTextInEnvelope, envelope
+		}
+		return envelope.ReencryptedEnvelope, envelope
+	})
 	if err != nil {
-		return
+		return ReencryptReport{ReencryptedText: encryptedTextWithEnvelopes}, err
 	}
 
-	reencryptedText = string(r.ReplaceAllFunc([]byte(encryptedTextWithEnvelopes), func(encryptedTextInEnvelope []byte) []byte {
+	report.ReencryptedText = reencryptedText
+	report.Key = key
+	report.Envelopes = envelopes
 
-		decryptedText, pipelineAllowList, err := sh.decryptEnvelope(string(encryptedTextInEnvelope), pipeline, false)
-		if err != nil {
-			return nil
+	var reencryptErrs []error
+	for _, envelope := range envelopes {
+		if envelope.Err != nil {
+			reencryptErrs = append(reencryptErrs, envelope.Err)
 		}
+	}
 
-		reencryptedTextInEnvelope, err := sh.encryptEnvelopeWithKey(decryptedText, pipelineAllowList, key, base64encodedKey)
-		if err != nil {
-			return nil
+	if len(reencryptErrs) > 0 {
+		err = errors.Join(reencryptErrs...)
+		if o.allOrNothing {
+			report.ReencryptedText = encryptedTextWithEnvelopes
+			report.Key = ""
 		}
+	}
 
-		return []byte(reencryptedTextInEnvelope)
-	}))
-
-	return reencryptedText, key, nil
+	return
 }
 
-func (sh *secretHelperImpl) GetAllSecretEnvelopes(input string) (envelopes []string, err error) {
+// reencryptEnvelope decrypts an envelope for the pipeline and encrypts it again with the same restrictions, converting
+// the pipeline allow list of a legacy secret; the error is located at the offset of the envelope
+func (sh *secretHelperImpl) reencryptEnvelope(ctx context.Context, encryptedTextInEnvelope string, offset int, pipeline string, encryptEnvelope func(unencryptedText string, restrictions secretRestrictions) (string, error)) (envelope ReencryptedEnvelope) {
 
-	r, err := regexp.Compile(SecretEnvelopeRegex)
-	if err != nil {
-		return
+	envelope = ReencryptedEnvelope{
+		Offset:   offset,
+		Envelope: encryptedTextInEnvelope,
 	}
 
-	matches := r.FindAllStringSubmatch(input, -1)
-	if matches != nil {
-		for _, m := range matches {
-			if len(m) > 1 {
-				envelopes = append(envelopes, m[0])
-			}
+	decryptedText, restrictions, err := sh.decryptEnvelope(ctx, AuditOperationReencrypt, encryptedTextInEnvelope, pipelineScope(pipeline), false)
+	if err == nil {
+		if isLegacySecret(encryptedTextInEnvelope) {
+			restrictions.PipelineAllowList = convertLegacyPipelineAllowList(restrictions.PipelineAllowList)
 		}
+		envelope.PipelineAllowList = restrictions.PipelineAllowList
+		envelope.ReencryptedEnvelope, err = encryptEnvelope(decryptedText, restrictions)
+	}
+	if err != nil {
+		envelope.Err = secretErrorAt(err, encryptedTextInEnvelope, offset)
 	}
 
 	return
 }
 
-func (sh *secretHelperImpl) GetAllSecrets(input string) (secrets []string, err error) {
+func (sh *secretHelperImpl) RewrapAllEnvelopes(encryptedTextWithEnvelopes string, options ...BulkOption) (rewrappedText string, err error) {
+	return sh.RewrapAllEnvelopesContext(context.Background(), encryptedTextWithEnvelopes, options...)
+}
+
+func (sh *secretHelperImpl) RewrapAllEnvelopesContext(ctx context.Context, encryptedTextWithEnvelopes string, options ...BulkOption) (rewrappedText string, err error) {
 
-	r, err := regexp.Compile(SecretEnvelopeRegex)
+	o := newBulkOptions(options)
+
+	// secrets that aren't encrypted with a data key are left untouched, as are the ones that fail to rewrap
+	rewrappedText, errs, err := replaceAllEnvelopes(ctx, encryptedTextWithEnvelopes, o.workers, func(encryptedTextInEnvelope string, offset int) (string, error) {
+		rewrappedEnvelope, err := sh.rewrapEnvelope(ctx, encryptedTextInEnvelope)
+		if err != nil {
+			return encryptedTextInEnvelope, secretErrorAt(err, encryptedTextInEnvelope, offset)
+		}
+		return rewrappedEnvelope, nil
+	})
 	if err != nil {
-		return
+		return encryptedTextWithEnvelopes, err
 	}
 
-	matches := r.FindAllStringSubmatch(input, -1)
-	if matches != nil {
-		for _, m := range matches {
-			if len(m) > 1 {
-				secrets = append(secrets, m[1])
-			}
+	if err = errors.Join(errs...); err != nil {
+		if o.allOrNothing {
+			rewrappedText = encryptedTextWithEnvelopes
 		}
 	}
 
 	return
 }
 
-func (sh *secretHelperImpl) GetAllSecretValues(input, pipeline string) (values []string, err error) {
+// rewrapEnvelope unwraps the data key of a secret and wraps it with the active master key, without touching the sealed
+// fields
+func (sh *secretHelperImpl) rewrapEnvelope(ctx context.Context, encryptedTextInEnvelope string) (rewrappedEnvelope string, err error) {
+
+	if sh.err != nil {
+		return "", newSecretError(ErrInvalidKey, sh.err)
+	}
 
-	r, err := regexp.Compile(SecretEnvelopeRegex)
+	encryptedTextPlusNonce, ok := unwrapEnvelope(encryptedTextInEnvelope)
+	if !ok {
+		return encryptedTextInEnvelope, nil
+	}
+
+	secret, err := parseEncryptedSecret(encryptedTextPlusNonce)
 	if err != nil {
 		return
 	}
+	if secret.wrappedKey == nil {
+		return encryptedTextInEnvelope, nil
+	}
 
-	matches := r.FindAllStringSubmatch(input, -1)
-	if matches != nil {
-		for _, m := range matches {
-			if len(m) > 1 {
-				decryptedText, _, err := sh.Decrypt(m[1], pipeline)
-				if err != nil {
-					return []string{}, err
-				}
-				values = append(values, decryptedText)
-			}
+	dataKey, err := sh.keyWrapper.UnwrapKey(ctx, secret.keyID, secret.wrappedKey)
+	if err != nil {
+		err = newKeyWrapperError(err)
+		sh.observe(ctx, AuditOperationRewrap, encryptedTextPlusNonce, "", Scope{}, "", err)
+		return "", err
+	}
+	masterKeyID, wrappedKey, err := sh.wrapKey(ctx, dataKey)
+	if err != nil {
+		err = newKeyWrapperError(err)
+		sh.observe(ctx, AuditOperationRewrap, encryptedTextPlusNonce, "", Scope{}, "", err)
+		return "", err
+	}
+
+	sealedFields := strings.SplitN(encryptedTextPlusNonce, ".", 4)[3]
+	rewrappedTextPlusNonce := fmt.Sprintf("%v.%v.%v.%v", secretFormatV2WrappedKey, masterKeyID, base64.URLEncoding.EncodeToString(wrappedKey), sealedFields)
+	sh.observe(ctx, AuditOperationRewrap, rewrappedTextPlusNonce, "", Scope{}, "", nil)
+
+	return fmt.Sprintf("ziplinee.secret(%v)", rewrappedTextPlusNonce), nil
+}
+
+// getReencryptTarget returns the function that encrypts envelopes for the target of a re-encryption and the target
+// key, which is a newly generated 32 bytes key unless a target key or secret helper is set in the options
+func (sh *secretHelperImpl) getReencryptTarget(ctx context.Context, o *bulkOptions, base64encodedKey bool) (encryptEnvelope func(unencryptedText string, restrictions secretRestrictions) (string, error), key string, err error) {
+
+	if o.targetSecretHelper != nil {
+		encryptEnvelope = func(unencryptedText string, restrictions secretRestrictions) (string, error) {
+			return o.targetSecretHelper.EncryptEnvelopeContext(ctx, unencryptedText, restrictions.PipelineAllowList, restrictions.encryptOptions()...)
+		}
+		return encryptEnvelope, "", nil
+	}
+
+	key = o.targetKey
+	if key != "" {
+		base64encodedKey = o.targetKeyBase64Encoded
+	} else {
+		// generate 32 bytes key
+		key, err = sh.GenerateKey(32, base64encodedKey)
+		if err != nil {
+			return
+		}
+	}
+
+	keyBytes, err := decodeKey(key, base64encodedKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	if _, err = sh.aeads.get(keyBytes); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	keyID := deriveKeyID(keyBytes)
+
+	encryptEnvelope = func(unencryptedText string, restrictions secretRestrictions) (string, error) {
+		encryptedText, err := sh.encryptWithKey(unencryptedText, restrictions, keyID, keyBytes)
+		sh.observe(ctx, AuditOperationReencrypt, encryptedText, sh.fingerprint(unencryptedText), Scope{}, restrictions.PipelineAllowList, err)
+		if err != nil {
+			return "", err
 		}
+		return fmt.Sprintf("ziplinee.secret(%v)", encryptedText), nil
+	}
+
+	return encryptEnvelope, key, nil
+}
+
+// replaceAllEnvelopes replaces every secret envelope in the input with the replacement returned by replace, which
+// receives the envelope and its byte offset in the input, and returns the results of replace in the order of the
+// envelopes; replace is called for up to workers envelopes at a time, so it has to be safe for concurrent use if
+// workers is more than 1; it stops with the context's error once the context is done
+func replaceAllEnvelopes[T any](ctx context.Context, input string, workers int, replace func(encryptedTextInEnvelope string, offset int) (string, T)) (output string, results []T, err error) {
+
+	locations := findAllEnvelopes(input)
+	replacements := make([]string, len(locations))
+	results = make([]T, len(locations))
+	err = forEachIndex(ctx, len(locations), workers, func(i int) {
+		replacements[i], results[i] = replace(input[locations[i].start:locations[i].end], locations[i].start)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(input))
+	lastIndex := 0
+	for i, loc := range locations {
+		sb.WriteString(input[lastIndex:loc.start])
+		sb.WriteString(replacements[i])
+		lastIndex = loc.end
+	}
+	sb.WriteString(input[lastIndex:])
+
+	return sb.String(), results, nil
+}
+
+func (sh *secretHelperImpl) GetAllSecretEnvelopes(input string) (envelopes []string, err error) {
+	return GetAllSecretEnvelopes(input)
+}
+
+// GetAllSecretEnvelopes returns every secret in its envelope in input, in order; it needs no key
+func GetAllSecretEnvelopes(input string) (envelopes []string, err error) {
+
+	for _, loc := range findAllEnvelopes(input) {
+		envelopes = append(envelopes, input[loc.start:loc.end])
 	}
 
 	return
 }
 
-func (sh *secretHelperImpl) GetInvalidRestrictedSecrets(input, pipeline string) (invalidSecrets []string, err error) {
+func (sh *secretHelperImpl) GetAllSecrets(input string) (secrets []string, err error) {
+	return GetAllSecrets(input)
+}
 
-	r, err := regexp.Compile(SecretEnvelopeRegex)
-	if err != nil {
+// GetAllSecrets returns every secret in input without its envelope, in order; it needs no key
+func GetAllSecrets(input string) (secrets []string, err error) {
+
+	for _, loc := range findAllEnvelopes(input) {
+		secrets = append(secrets, loc.secret(input))
+	}
+
+	return
+}
+
+func (sh *secretHelperImpl) GetAllSecretValues(input, pipeline string, options ...BulkOption) (values []string, err error) {
+	return sh.GetAllSecretValuesContext(context.Background(), input, pipelineScope(pipeline), options...)
+}
+
+func (sh *secretHelperImpl) GetAllSecretValuesContext(ctx context.Context, input string, scope Scope, options ...BulkOption) (values []string, err error) {
+
+	o := newBulkOptions(options)
+
+	locations := findAllEnvelopes(input)
+	if len(locations) == 0 {
 		return
 	}
 
-	matches := r.FindAllStringSubmatch(input, -1)
-	if matches != nil {
-		for _, m := range matches {
-			if len(m) > 1 {
-				_, _, err := sh.Decrypt(m[1], pipeline)
-				if err != nil && errors.Is(err, ErrRestrictedSecret) {
-					invalidSecrets = append(invalidSecrets, m[0])
-				}
-			}
+	// every envelope is decrypted, so the error is the one of the first envelope in the input that fails, regardless of
+	// which worker finishes first
+	values = make([]string, len(locations))
+	errs := make([]error, len(locations))
+	err = forEachIndex(ctx, len(locations), o.workers, func(i int) {
+		values[i], _, errs[i] = sh.decrypt(ctx, AuditOperationDecrypt, locations[i].secret(input), scope, true)
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	for i, err := range errs {
+		if err != nil {
+			return []string{}, secretErrorAt(err, input[locations[i].start:locations[i].end], locations[i].start)
+		}
+	}
+
+	return
+}
+
+func (sh *secretHelperImpl) GetInvalidRestrictedSecrets(input, pipeline string) (invalidSecrets []string, err error) {
+
+	for _, loc := range findAllEnvelopes(input) {
+		_, _, err := sh.Decrypt(loc.secret(input), pipeline)
+		if err != nil && errors.Is(err, ErrRestrictedSecret) {
+			invalidSecrets = append(invalidSecrets, input[loc.start:loc.end])
 		}
 	}
 
@@ -401,3 +1025,34 @@ func (sh *secretHelperImpl) GetInvalidRestrictedSecrets(input, pipeline string)
 
 	return
 }
+
+func (sh *secretHelperImpl) GetExpiringSecrets(input string, within time.Duration) (expiringSecrets []ExpiringSecret, err error) {
+	return sh.GetExpiringSecretsContext(context.Background(), input, within)
+}
+
+func (sh *secretHelperImpl) GetExpiringSecretsContext(ctx context.Context, input string, within time.Duration) (expiringSecrets []ExpiringSecret, err error) {
+
+	// the expiry time is sealed with the restrictions of the secret, so only those are opened and the value never is;
+	// the secrets that fail are reported but don't stop the scan
+	deadline := sh.now().Add(within)
+	var decryptErrs []error
+	for _, loc := range findAllEnvelopes(input) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		restrictions, err := sh.inspectRestrictions(ctx, loc.secret(input))
+		if err != nil {
+			decryptErrs = append(decryptErrs, secretErrorAt(err, input[loc.start:loc.end], loc.start))
+			continue
+		}
+		if restrictions.ExpiresAt != 0 && time.Unix(restrictions.ExpiresAt, 0).Before(deadline) {
+			expiringSecrets = append(expiringSecrets, ExpiringSecret{
+				Offset:    loc.start,
+				Envelope:  input[loc.start:loc.end],
+				ExpiresAt: time.Unix(restrictions.ExpiresAt, 0),
+			})
+		}
+	}
+
+	return expiringSecrets, errors.Join(decryptErrs...)
+}
@@ -0,0 +1,72 @@
+package crypt
+
+import (
+	"fmt"
+	"time"
+)
+
+// SecretHelperOption configures a SecretHelper when it's created
+type SecretHelperOption func(*secretHelperImpl)
+
+func applySecretHelperOptions(sh *secretHelperImpl, options []SecretHelperOption) *secretHelperImpl {
+
+	sh.now = time.Now
+	for _, option := range options {
+		option(sh)
+	}
+
+	return sh
+}
+
+// RepositoryMove declares that the pipeline of a repository moved to another name, for example because the repository
+// was renamed or transferred to another owner
+type RepositoryMove struct {
+	// From is the pipeline the repository moved from, like github.com/oldowner/repo
+	From string
+	// To is the pipeline the repository moved to, like github.com/newowner/repo
+	To string
+}
+
+// WithRepositoryMoves lets the pipeline a repository moved to decrypt the secrets restricted to the pipeline it moved
+// from; moves are followed in chains, but never grant access to pipelines that aren't declared
+func WithRepositoryMoves(moves ...RepositoryMove) SecretHelperOption {
+	return func(sh *secretHelperImpl) {
+		sh.repositoryMoves = append(sh.repositoryMoves, moves...)
+	}
+}
+
+// WithClock sets the clock the not-before and expiry times of secrets are checked against, instead of time.Now
+func WithClock(now func() time.Time) SecretHelperOption {
+	return func(sh *secretHelperImpl) {
+		sh.now = now
+	}
+}
+
+// WithAuditObserver registers the observer to receive an AuditEvent for every secret that's encrypted, decrypted,
+// re-encrypted, rewrapped or inspected
+func WithAuditObserver(observer AuditObserver) SecretHelperOption {
+	return func(sh *secretHelperImpl) {
+		sh.observer = observer
+	}
+}
+
+// WithFingerprintKey sets the key fingerprints of secret values are derived with; use the same key everywhere
+// fingerprints are compared, and keep it as secret as the keys the secrets are encrypted with, because anyone holding
+// it can confirm guesses of a value
+func WithFingerprintKey(key string, base64encodedKey bool) SecretHelperOption {
+	return func(sh *secretHelperImpl) {
+
+		keyBytes, err := decodeKey(key, base64encodedKey)
+		if err == nil && len(keyBytes) < minFingerprintKeySize {
+			err = fmt.Errorf("the fingerprint key has %v bytes, it needs at least %v", len(keyBytes), minFingerprintKeySize)
+		}
+		if err != nil {
+			if sh.err == nil {
+				sh.err = err
+			}
+			return
+		}
+
+		sh.fingerprintKey = keyBytes
+	}
+}
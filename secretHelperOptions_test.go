@@ -0,0 +1,126 @@
+package crypt
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRepositoryMoves(t *testing.T) {
+
+	// the secret in here is restricted to github.com/ziplineeci/ziplinee-ci-api
+	legacyEncryptedTextPlusNonce := "ggyRBRZW_ofbXRgl.DdPeqg-ulQEKBuiCC_XZVscTrt4yFRxDE_u_mf8OiNtb.HtLDsVqlyEIIEueLB-bHWt1dpHAK7tmKQoHw0cKc5SzZK9Yd1Jh_K5JS0YrmKu91wJpFEDQ="
+
+	t.Run("ReturnsErrRestrictedSecretForSameRepositoryNameUnderOtherOwnerWithoutMoves", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		// act
+		_, _, err := secretHelper.Decrypt(legacyEncryptedTextPlusNonce, "github.com/otherowner/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
+	})
+
+	t.Run("ReturnsErrRestrictedSecretIfPipelineAllowListHasNoSlashes", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
+	})
+
+	t.Run("ReturnsDecryptedSecretForPipelineRepositoryMovedTo", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithRepositoryMoves(
+			RepositoryMove{From: "github.com/ziplineeci/ziplinee-ci-api", To: "github.com/neworg/ziplinee-ci-api"},
+		))
+
+		// act
+		decryptedText, _, err := secretHelper.Decrypt(legacyEncryptedTextPlusNonce, "github.com/neworg/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+	})
+
+	t.Run("ReturnsDecryptedSecretForChainOfMoves", func(t *testing.T) {
+
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("", "SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		assert.Nil(t, err)
+		secretHelper := NewSecretHelperWithKeyring(keyring, WithRepositoryMoves(
+			RepositoryMove{From: "github.com/ziplineeci/ziplinee-ci-api", To: "github.com/neworg/ziplinee-ci-api"},
+			RepositoryMove{From: "github.com/neworg/ziplinee-ci-api", To: "github.com/neworg/api"},
+		))
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		// act
+		decryptedText, _, err := secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/neworg/api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+	})
+
+	t.Run("ReturnsErrRestrictedSecretForPipelineRepositoryMovedFrom", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithRepositoryMoves(
+			RepositoryMove{From: "github.com/neworg/ziplinee-ci-api", To: "github.com/ziplineeci/ziplinee-ci-api"},
+		))
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/neworg/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
+	})
+
+	t.Run("ReturnsErrRestrictedSecretForCycleOfMoves", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithRepositoryMoves(
+			RepositoryMove{From: "github.com/a/repo", To: "github.com/b/repo"},
+			RepositoryMove{From: "github.com/b/repo", To: "github.com/a/repo"},
+		))
+
+		// act
+		_, _, err := secretHelper.Decrypt(legacyEncryptedTextPlusNonce, "github.com/a/repo")
+
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
+	})
+}
+
+func TestWithClock(t *testing.T) {
+
+	t.Run("ReturnsErrSecretExpiredIfClockIsPastExpiresAt", func(t *testing.T) {
+
+		expiresAt := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithClock(func() time.Time { return expiresAt.Add(time.Second) }))
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList, ExpiresAt(expiresAt))
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrSecretExpired))
+	})
+
+	t.Run("ReturnsDecryptedSecretIfClockIsBeforeExpiresAt", func(t *testing.T) {
+
+		expiresAt := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithClock(func() time.Time { return expiresAt.Add(-time.Second) }))
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList, ExpiresAt(expiresAt))
+		assert.Nil(t, err)
+
+		// act
+		decryptedText, _, err := secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+	})
+}
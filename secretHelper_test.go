@@ -1,16 +1,22 @@
 package crypt
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestEncrypt(t *testing.T) {
 
-	t.Run("ReturnsEncryptedValueWithNonceDotEncryptedStringIfPipelineAllowListIsEmpty", func(t *testing.T) {
+	t.Run("ReturnsEncryptedValueWithVersionDotKeyIDDotNonceDotEncryptedStringIfPipelineAllowListIsEmpty", func(t *testing.T) {
 
 		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
 		originalText := "this is my secret"
@@ -21,12 +27,13 @@ func TestEncrypt(t *testing.T) {
 
 		assert.Nil(t, err)
 		splittedStrings := strings.Split(encryptedTextPlusNonce, ".")
-		assert.Equal(t, 2, len(splittedStrings))
-		assert.Equal(t, 16, len(splittedStrings[0]))
-		// fmt.Println(encryptedTextPlusNonce)
+		assert.Equal(t, 4, len(splittedStrings))
+		assert.Equal(t, "v2", splittedStrings[0])
+		assert.Equal(t, "oTlW9djx", splittedStrings[1])
+		assert.Equal(t, 16, len(splittedStrings[2]))
 	})
 
-	t.Run("ReturnsEncryptedValueWithNonceDotEncryptedStringIfPipelineAllowListIsDefault", func(t *testing.T) {
+	t.Run("ReturnsEncryptedValueWithVersionDotKeyIDDotNonceDotEncryptedStringIfPipelineAllowListIsDefault", func(t *testing.T) {
 
 		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
 		originalText := "this is my secret"
@@ -37,12 +44,13 @@ func TestEncrypt(t *testing.T) {
 
 		assert.Nil(t, err)
 		splittedStrings := strings.Split(encryptedTextPlusNonce, ".")
-		assert.Equal(t, 2, len(splittedStrings))
-		assert.Equal(t, 16, len(splittedStrings[0]))
-		// fmt.Println(encryptedTextPlusNonce)
+		assert.Equal(t, 4, len(splittedStrings))
+		assert.Equal(t, "v2", splittedStrings[0])
+		assert.Equal(t, "oTlW9djx", splittedStrings[1])
+		assert.Equal(t, 16, len(splittedStrings[2]))
 	})
 
-	t.Run("ReturnsEncryptedValueWithNonceDotEncryptedStringDotPipelineAllowListIfPipelineAllowListIsNonDefault", func(t *testing.T) {
+	t.Run("ReturnsEncryptedValueWithVersionDotKeyIDDotNonceDotEncryptedStringDotNonceDotPipelineAllowListIfPipelineAllowListIsNonDefault", func(t *testing.T) {
 
 		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
 		originalText := "this is my secret"
@@ -53,10 +61,53 @@ func TestEncrypt(t *testing.T) {
 
 		assert.Nil(t, err)
 		splittedStrings := strings.Split(encryptedTextPlusNonce, ".")
-		assert.Equal(t, 3, len(splittedStrings))
-		assert.Equal(t, 16, len(splittedStrings[0]))
-		// fmt.Println(encryptedTextPlusNonce)
-		// assert.Fail(t, "show me the encrypted value")
+		assert.Equal(t, 6, len(splittedStrings))
+		assert.Equal(t, "v2", splittedStrings[0])
+		assert.Equal(t, "oTlW9djx", splittedStrings[1])
+		assert.Equal(t, 16, len(splittedStrings[2]))
+		assert.Equal(t, 16, len(splittedStrings[4]))
+	})
+
+	t.Run("ReturnsDifferentNoncesForValueAndPipelineAllowList", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		originalText := "this is my secret"
+		pipelineAllowList := "github.com/ziplineeci/ziplinee-ci-api"
+
+		// act
+		encryptedTextPlusNonce, err := secretHelper.Encrypt(originalText, pipelineAllowList)
+
+		assert.Nil(t, err)
+		splittedStrings := strings.Split(encryptedTextPlusNonce, ".")
+		if !assert.Equal(t, 6, len(splittedStrings)) {
+			return
+		}
+		assert.NotEqual(t, splittedStrings[2], splittedStrings[4])
+	})
+
+	t.Run("ReturnsErrInvalidPipelineAllowListIfPipelineAllowListIsUnprefixedRegex", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		// act
+		_, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/.*")
+
+		assert.True(t, errors.Is(err, ErrInvalidPipelineAllowList))
+	})
+
+	t.Run("ReturnsSecretForPipelinesMatchingAnyEntryOfPipelineAllowList", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		// act
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", " github.com/ziplineeci/ziplinee-ci-api , github.com/other/* ")
+
+		assert.Nil(t, err)
+		_, pipelineAllowList, err := secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/other/repo")
+		assert.Nil(t, err)
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api,github.com/other/*", pipelineAllowList)
+		_, _, err = secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-web")
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
 	})
 }
 
@@ -160,6 +211,66 @@ func TestDecrypt(t *testing.T) {
 		assert.Equal(t, "github.com/ziplineeci/.+", pipelineAllowList)
 	})
 
+	t.Run("ReturnsOriginalValueAndPipelineAllowListForVersion2Secret", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		originalText := "this is my secret"
+		encryptedTextPlusNonce, err := secretHelper.Encrypt(originalText, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		pipeline := "github.com/ziplineeci/ziplinee-ci-api"
+
+		// act
+		decryptedText, pipelineAllowList, err := secretHelper.Decrypt(encryptedTextPlusNonce, pipeline)
+
+		assert.Nil(t, err)
+		assert.Equal(t, originalText, decryptedText)
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", pipelineAllowList)
+	})
+
+	t.Run("ReturnsErrorIfVersion2SecretHasWrongNumberOfParts", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		pipeline := "github.com/ziplineeci/ziplinee-ci-api"
+
+		// act
+		_, _, err = secretHelper.Decrypt(encryptedTextPlusNonce[:strings.LastIndex(encryptedTextPlusNonce, ".")], pipeline)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfPipelineAllowListIsStrippedFromVersion2Secret", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		splittedStrings := strings.Split(encryptedTextPlusNonce, ".")
+		pipeline := "github.com/ziplineeci/ziplinee-ci-web"
+
+		// act
+		_, _, err = secretHelper.Decrypt(strings.Join(splittedStrings[:4], "."), pipeline)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfPipelineAllowListIsSwappedBetweenVersion2Secrets", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		otherEncryptedTextPlusNonce, err := secretHelper.Encrypt("this is another secret", "github.com/ziplineeci/ziplinee-ci-web")
+		assert.Nil(t, err)
+		splittedStrings := strings.Split(encryptedTextPlusNonce, ".")
+		otherSplittedStrings := strings.Split(otherEncryptedTextPlusNonce, ".")
+		pipeline := "github.com/ziplineeci/ziplinee-ci-web"
+
+		// act
+		_, _, err = secretHelper.Decrypt(strings.Join(append(splittedStrings[:4], otherSplittedStrings[4:]...), "."), pipeline)
+
+		assert.NotNil(t, err)
+	})
+
 	t.Run("ReturnsErrorIfPipelineDoesNotMatchPipelineAllowListRegex", func(t *testing.T) {
 
 		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
@@ -173,6 +284,114 @@ func TestDecrypt(t *testing.T) {
 	})
 }
 
+func TestDecryptWithScope(t *testing.T) {
+
+	t.Run("ReturnsDecryptedSecretIfScopeMatchesRestrictions", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api", RestrictToBranches("main"), RestrictToEvents("release"))
+		assert.Nil(t, err)
+		scope := Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api", Branch: "main", Event: "release", ReleaseTarget: "production"}
+
+		// act
+		decryptedText, pipelineAllowList, err := secretHelper.DecryptContext(context.Background(), encryptedTextPlusNonce, scope)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", pipelineAllowList)
+	})
+
+	t.Run("ReturnsErrSecretOutOfScopeIfBranchIsNotAllowed", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", "github.com/ziplineeci/ziplinee-ci-api", RestrictToBranches("main"))
+		assert.Nil(t, err)
+		scope := Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api", Branch: "feature", Event: "pull_request"}
+
+		// act
+		_, _, err = secretHelper.DecryptContext(context.Background(), encryptedTextPlusNonce, scope)
+
+		assert.True(t, errors.Is(err, ErrSecretOutOfScope))
+	})
+
+	t.Run("ReturnsErrSecretOutOfScopeForDecryptWithoutBranch", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList, RestrictToBranches("main"))
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = secretHelper.Decrypt(encryptedTextPlusNonce, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.True(t, errors.Is(err, ErrSecretOutOfScope))
+	})
+
+	t.Run("ReturnsErrorIfRestrictionsAreSwappedBetweenSecrets", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList, RestrictToBranches("main"))
+		assert.Nil(t, err)
+		otherEncryptedTextPlusNonce, err := secretHelper.Encrypt("this is another secret", DefaultPipelineAllowList, RestrictToBranches("*"))
+		assert.Nil(t, err)
+		splittedStrings := strings.Split(encryptedTextPlusNonce, ".")
+		otherSplittedStrings := strings.Split(otherEncryptedTextPlusNonce, ".")
+
+		// act
+		_, _, err = secretHelper.DecryptContext(context.Background(), strings.Join(append(splittedStrings[:4], otherSplittedStrings[4:]...), "."), Scope{Branch: "feature"})
+
+		assert.True(t, errors.Is(err, ErrTamperedSecret))
+	})
+
+	t.Run("KeepsRestrictionsWhenReencrypting", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList, RestrictToStages("deploy"))
+		assert.Nil(t, err)
+		reencryptedText, key, err := secretHelper.ReencryptAllEnvelopes(envelope, "", false)
+		assert.Nil(t, err)
+
+		// act
+		_, _, err = NewSecretHelper(key, false).DecryptEnvelopeContext(context.Background(), reencryptedText, Scope{Stage: "build"})
+
+		assert.True(t, errors.Is(err, ErrSecretOutOfScope))
+	})
+}
+
+func TestDecryptContext(t *testing.T) {
+
+	t.Run("ReturnsDecryptedSecretForContextThatIsNotDone", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		encryptedTextPlusNonce, err := secretHelper.Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		decryptedText, _, err := secretHelper.DecryptContext(context.Background(), encryptedTextPlusNonce, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+	})
+
+	t.Run("PassesContextToKeyProvider", func(t *testing.T) {
+
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "active"), []byte("2024-01"), 0600)
+		assert.Nil(t, err)
+		err = os.WriteFile(filepath.Join(dir, "2024-01.key"), []byte("U2F6YndNZjNOWnhWVmJCcVFIZWJQY1hDcXJWbjNERHA="), 0600)
+		assert.Nil(t, err)
+		encryptedTextPlusNonce, err := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false).Encrypt("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// act
+		_, _, err = NewSecretHelperWithKeyProvider(NewLocalKMSKeyProvider(dir, time.Hour)).DecryptContext(ctx, encryptedTextPlusNonce, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"})
+
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.True(t, errors.Is(err, ErrKeyUnavailable))
+	})
+}
+
 func TestDecryptEnvelope(t *testing.T) {
 
 	t.Run("ReturnsOriginalValue", func(t *testing.T) {
@@ -213,6 +432,40 @@ func TestDecryptEnvelope(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, builderConfigJSON, decryptedText)
 	})
+
+	t.Run("ReturnsEmptyStringForEmptyString", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		// act
+		decryptedText, _, err := secretHelper.DecryptEnvelope("", "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "", decryptedText)
+	})
+}
+
+func TestIsEncryptedEnvelope(t *testing.T) {
+
+	t.Run("ReturnsTrueForEnvelope", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		// act
+		isEnvelope := secretHelper.IsEncryptedEnvelope("ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)")
+
+		assert.True(t, isEnvelope)
+	})
+
+	t.Run("ReturnsFalseForEmptyString", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		// act
+		isEnvelope := secretHelper.IsEncryptedEnvelope("")
+
+		assert.False(t, isEnvelope)
+	})
 }
 
 func TestDecryptAllEnvelopes(t *testing.T) {
@@ -231,6 +484,114 @@ func TestDecryptAllEnvelopes(t *testing.T) {
 		assert.Equal(t, expectedValue, decryptedText)
 
 	})
+
+	t.Run("ReturnsLastErrorAndReplacesFailingEnvelopesWithEmptyString", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := "a: ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u), b: ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=), c: ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)"
+		pipeline := "github.com/ziplineeci/ziplinee-ci-web"
+
+		// act
+		decryptedText, err := secretHelper.DecryptAllEnvelopes(input, pipeline)
+
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
+		assert.False(t, errors.Is(err, ErrMalformedSecret))
+		assert.Equal(t, "a: , b: , c: this is my secret", decryptedText)
+	})
+
+	t.Run("ReturnsAllErrorsWithTheirOffsetsIfCollectAllErrorsIsSet", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := "a: ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u), b: ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=), c: ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)"
+		pipeline := "github.com/ziplineeci/ziplinee-ci-web"
+
+		// act
+		_, err := secretHelper.DecryptAllEnvelopes(input, pipeline, CollectAllErrors())
+
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+		joinedErr, ok := err.(interface{ Unwrap() []error })
+		if assert.True(t, ok) && assert.Equal(t, 2, len(joinedErr.Unwrap())) {
+			var firstErr, secondErr *SecretError
+			assert.True(t, errors.As(joinedErr.Unwrap()[0], &firstErr))
+			assert.True(t, errors.As(joinedErr.Unwrap()[1], &secondErr))
+			assert.Equal(t, 3, firstErr.Offset)
+			assert.Equal(t, strings.Index(input, "ziplinee.secret(n-Wq"), secondErr.Offset)
+			assert.Equal(t, input[secondErr.Offset:strings.Index(input, ", c:")], secondErr.Envelope)
+		}
+	})
+
+	t.Run("LeavesFailingEnvelopesUntouchedIfKeepUndecryptableEnvelopesIsSet", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := "a: ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u), c: ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)"
+		pipeline := "github.com/ziplineeci/ziplinee-ci-web"
+
+		// act
+		decryptedText, err := secretHelper.DecryptAllEnvelopes(input, pipeline, KeepUndecryptableEnvelopes())
+
+		assert.NotNil(t, err)
+		assert.Equal(t, "a: ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u), c: this is my secret", decryptedText)
+	})
+
+	t.Run("ReturnsSameTextAndErrorsInOrderOfEnvelopesIfWithWorkersIsSet", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		restrictedEnvelope, err := secretHelper.EncryptEnvelope("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		var sb strings.Builder
+		for i := 0; i < 100; i++ {
+			if i%10 == 0 {
+				fmt.Fprintf(&sb, "key%v: %v\n", i, restrictedEnvelope)
+			} else {
+				fmt.Fprintf(&sb, "key%v: %v\n", i, envelope)
+			}
+		}
+		input := sb.String()
+		expectedText, expectedErr := secretHelper.DecryptAllEnvelopes(input, "github.com/ziplineeci/ziplinee-ci-web", CollectAllErrors())
+
+		// act
+		decryptedText, err := secretHelper.DecryptAllEnvelopes(input, "github.com/ziplineeci/ziplinee-ci-web", CollectAllErrors(), WithWorkers(8))
+
+		assert.Equal(t, expectedText, decryptedText)
+		assert.Equal(t, expectedErr.Error(), err.Error())
+		assert.Equal(t, 10, len(err.(interface{ Unwrap() []error }).Unwrap()))
+	})
+
+}
+
+func TestDecryptAllEnvelopesContext(t *testing.T) {
+
+	t.Run("ReturnsErrorIfContextIsCancelled", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// act
+		decryptedText, err := secretHelper.DecryptAllEnvelopesContext(ctx, "key1: "+envelope+"\nkey2: "+envelope, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"})
+
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equal(t, "", decryptedText)
+	})
+
+	t.Run("ReturnsErrorIfDeadlineIsExceeded", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		// act
+		_, err = secretHelper.DecryptAllEnvelopesContext(ctx, "key: "+envelope, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"})
+
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
 }
 
 func TestReencryptAllEnvelopes(t *testing.T) {
@@ -324,6 +685,181 @@ func TestReencryptAllEnvelopes(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, expectedValue, decryptedText)
 	})
+
+	t.Run("KeepsEnvelopesThatFailToReencryptAndReturnsErrorForEachOfThem", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := "a: ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u), b: ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P), c: ziplinee.secret(34TwMlihi18JCWHS.bC7KcqyjxJu0bLYnnhLwyXRc1FpBdgWL861orEETEl5e)"
+		pipeline := "github.com/ziplineeci/ziplinee-ci-api"
+
+		// act
+		reencryptedText, key, err := secretHelper.ReencryptAllEnvelopes(input, pipeline, false)
+
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+		assert.True(t, errors.Is(err, ErrWrongKey))
+		assert.Equal(t, 32, len(key))
+		assert.True(t, strings.HasPrefix(reencryptedText, "a: ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u), b: ziplinee.secret(v2."))
+		assert.True(t, strings.HasSuffix(reencryptedText, ", c: ziplinee.secret(34TwMlihi18JCWHS.bC7KcqyjxJu0bLYnnhLwyXRc1FpBdgWL861orEETEl5e)"))
+	})
+
+	t.Run("ReturnsOriginalTextAndNoKeyIfAllOrNothingIsSetAndAnyEnvelopeFails", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := "a: ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u), b: ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)"
+		pipeline := "github.com/ziplineeci/ziplinee-ci-api"
+
+		// act
+		reencryptedText, key, err := secretHelper.ReencryptAllEnvelopes(input, pipeline, false, AllOrNothing())
+
+		assert.NotNil(t, err)
+		assert.Equal(t, "", key)
+		assert.Equal(t, input, reencryptedText)
+	})
+
+	t.Run("ReencryptsIntoTargetKeyIfWithTargetKeyIsSet", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := "a: ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)"
+		otherInput := "b: ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=)"
+		pipeline := "github.com/ziplineeci/ziplinee-ci-api"
+		targetKey := "AmHn8kQ0vH3z/s6MPzgrCRe3qDtP2W4CkPCbzSB8W9E="
+
+		// act
+		reencryptedText, key, err := secretHelper.ReencryptAllEnvelopes(input, pipeline, false, WithTargetKey(targetKey, true))
+		assert.Nil(t, err)
+		otherReencryptedText, otherKey, err := secretHelper.ReencryptAllEnvelopes(otherInput, pipeline, false, WithTargetKey(targetKey, true))
+		assert.Nil(t, err)
+
+		assert.Equal(t, targetKey, key)
+		assert.Equal(t, targetKey, otherKey)
+		values, err := NewSecretHelper(targetKey, true).GetAllSecretValues(reencryptedText+otherReencryptedText, pipeline)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"this is my secret", "this is my secret"}, values)
+	})
+
+	t.Run("ReturnsErrInvalidKeyIfTargetKeyIsInvalid", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := "a: ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)"
+		pipeline := "github.com/ziplineeci/ziplinee-ci-api"
+
+		// act
+		reencryptedText, _, err := secretHelper.ReencryptAllEnvelopes(input, pipeline, false, WithTargetKey("tooshort", false))
+
+		assert.True(t, errors.Is(err, ErrInvalidKey))
+		assert.Equal(t, input, reencryptedText)
+	})
+
+	t.Run("ReencryptsWithTargetSecretHelperIfWithTargetSecretHelperIsSet", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		keyring := NewKeyring()
+		_, err := keyring.AddKey("2024-01", "7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)
+		assert.Nil(t, err)
+		targetSecretHelper := NewSecretHelperWithKeyring(keyring)
+		input := "b: ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=)"
+		pipeline := "github.com/ziplineeci/ziplinee-ci-api"
+
+		// act
+		reencryptedText, key, err := secretHelper.ReencryptAllEnvelopes(input, pipeline, false, WithTargetSecretHelper(targetSecretHelper))
+
+		assert.Nil(t, err)
+		assert.Equal(t, "", key)
+		assert.True(t, strings.HasPrefix(reencryptedText, "b: ziplinee.secret(v2.2024-01."))
+		decryptedText, pipelineAllowList, err := targetSecretHelper.DecryptEnvelope(strings.TrimPrefix(reencryptedText, "b: "), pipeline)
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", pipelineAllowList)
+	})
+}
+
+func TestReencryptAllEnvelopesContext(t *testing.T) {
+
+	t.Run("ReturnsOriginalTextIfContextIsCancelled", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// act
+		reencryptedText, key, err := secretHelper.ReencryptAllEnvelopesContext(ctx, "key: "+envelope, "github.com/ziplineeci/ziplinee-ci-api", false)
+
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equal(t, "key: "+envelope, reencryptedText)
+		assert.Equal(t, "", key)
+	})
+}
+
+func TestReencryptAllEnvelopesWithReport(t *testing.T) {
+
+	t.Run("ReturnsEntryForEveryEnvelope", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := "a: ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u), b: ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=)"
+		pipeline := "github.com/ziplineeci/ziplinee-ci-web"
+
+		// act
+		report, err := secretHelper.ReencryptAllEnvelopesWithReport(input, pipeline, true)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 44, len(report.Key))
+		if !assert.Equal(t, 2, len(report.Envelopes)) {
+			return
+		}
+		assert.Equal(t, 3, report.Envelopes[0].Offset)
+		assert.Equal(t, "", report.Envelopes[0].ReencryptedEnvelope)
+		assert.True(t, errors.Is(report.Envelopes[0].Err, ErrMalformedSecret))
+		assert.Equal(t, strings.Index(input, "ziplinee.secret(n-Wq"), report.Envelopes[1].Offset)
+		assert.Equal(t, input[report.Envelopes[1].Offset:], report.Envelopes[1].Envelope)
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", report.Envelopes[1].PipelineAllowList)
+		assert.Nil(t, report.Envelopes[1].Err)
+		assert.True(t, strings.HasSuffix(report.ReencryptedText, report.Envelopes[1].ReencryptedEnvelope))
+
+		decryptedText, pipelineAllowList, err := NewSecretHelper(report.Key, true).DecryptEnvelope(report.Envelopes[1].ReencryptedEnvelope, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+		assert.Equal(t, "github.com/ziplineeci/ziplinee-ci-api", pipelineAllowList)
+	})
+
+	t.Run("ConvertsRegexPipelineAllowListOfLegacySecret", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := "a: ziplinee.secret(I3tNvRAhMJ7LpG0F.PKpXTn1deA7w4BOacMNRKeUJq_F3vbHJ3ZEaeYlaejsY.L6tKVShWJU3y9ByTfNBPJf9crPjfUKrzAyQJt19-9waJ9r00-i7kIg==)"
+		pipeline := "github.com/ziplineeci/ziplinee-ci-web"
+
+		// act
+		report, err := secretHelper.ReencryptAllEnvelopesWithReport(input, pipeline, true)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "regex:github.com/ziplineeci/.+", report.Envelopes[0].PipelineAllowList)
+		decryptedText, _, err := NewSecretHelper(report.Key, true).DecryptEnvelope(report.Envelopes[0].ReencryptedEnvelope, pipeline)
+		assert.Nil(t, err)
+		assert.Equal(t, "this is my secret", decryptedText)
+	})
+
+	t.Run("ReturnsEntriesInOrderOfEnvelopesIfWithWorkersIsSet", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		input := strings.Repeat("key: "+envelope+"\n", 50)
+
+		// act
+		report, err := secretHelper.ReencryptAllEnvelopesWithReport(input, "github.com/ziplineeci/ziplinee-ci-api", false, WithWorkers(8))
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 50, len(report.Envelopes)) {
+			for i, envelope := range report.Envelopes {
+				assert.Equal(t, i*len("key: "+envelope.Envelope+"\n")+len("key: "), envelope.Offset)
+			}
+		}
+		decryptedText, err := NewSecretHelper(report.Key, false).DecryptAllEnvelopes(report.ReencryptedText, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		assert.Equal(t, strings.Repeat("key: this is my secret\n", 50), decryptedText)
+	})
+
 }
 
 func TestGetAllSecretEnvelopes(t *testing.T) {
@@ -417,6 +953,47 @@ func TestGetAllSecretValues(t *testing.T) {
 		assert.NotNil(t, err)
 		assert.Equal(t, 0, len(values))
 	})
+
+	t.Run("ReturnsErrorOfFirstFailingSecretIfWithWorkersIsSet", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		input := `
+		ziplinee.secret(MpHxojAPal_XIF_K.R4_LANCK38oT_KC90NyNOEwQUDitqR9Dznf1GGmLnO4P)
+
+		ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=)
+
+		ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u)
+		`
+
+		pipeline := "github.com/ziplineeci/ziplinee-ci-web"
+
+		// act
+		values, err := secretHelper.GetAllSecretValues(input, pipeline, WithWorkers(3))
+
+		assert.True(t, errors.Is(err, ErrRestrictedSecret))
+		assert.False(t, errors.Is(err, ErrMalformedSecret))
+		assert.Equal(t, 0, len(values))
+	})
+
+}
+
+func TestGetAllSecretValuesContext(t *testing.T) {
+
+	t.Run("ReturnsErrorIfContextIsCancelled", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// act
+		values, err := secretHelper.GetAllSecretValuesContext(ctx, "key: "+envelope, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"})
+
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equal(t, 0, len(values))
+	})
 }
 
 func TestGetInvalidRestrictedSecrets(t *testing.T) {
@@ -459,4 +1036,162 @@ func TestGetInvalidRestrictedSecrets(t *testing.T) {
 		assert.Equal(t, 1, len(invalidSecrets))
 		assert.Equal(t, "ziplinee.secret(n-WqaQnVu5zN8FZI.sYmyQx414B0xOYHqnTKNtaCQ7B4sIj91Q8pjYtpe83fV.ooivWEs-vV4zLY7jkSGTubrIQThCXbd-eVpZM6Bm4xUraOJsDf3pPulX1wSjVFf2OH7G-do=)", invalidSecrets[0])
 	})
+
+	t.Run("ReturnsNilForSecretRestrictedToBranchOfCurrentPipeline", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", "github.com/ziplineeci/ziplinee-ci-api", RestrictToBranches("main"), RestrictToStages("deploy"))
+		assert.Nil(t, err)
+
+		// act
+		invalidSecrets, err := secretHelper.GetInvalidRestrictedSecrets("x: "+envelope, "github.com/ziplineeci/ziplinee-ci-api")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(invalidSecrets))
+	})
+}
+
+func TestGetExpiringSecrets(t *testing.T) {
+
+	t.Run("ReturnsSecretsThatExpireWithinDuration", func(t *testing.T) {
+
+		now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithClock(func() time.Time { return now }))
+		expired, err := secretHelper.EncryptEnvelope("expired", DefaultPipelineAllowList, ExpiresAt(now.Add(-time.Hour)))
+		assert.Nil(t, err)
+		expiringSoon, err := secretHelper.EncryptEnvelope("expiring soon", DefaultPipelineAllowList, ExpiresAt(now.Add(24*time.Hour)))
+		assert.Nil(t, err)
+		expiringLater, err := secretHelper.EncryptEnvelope("expiring later", DefaultPipelineAllowList, ExpiresAt(now.Add(90*24*time.Hour)))
+		assert.Nil(t, err)
+		neverExpiring, err := secretHelper.EncryptEnvelope("never expiring", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		input := fmt.Sprintf("a: %v\nb: %v\nc: %v\nd: %v\n", expired, expiringSoon, expiringLater, neverExpiring)
+
+		// act
+		expiringSecrets, err := secretHelper.GetExpiringSecrets(input, 30*24*time.Hour)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(expiringSecrets)) {
+			assert.Equal(t, expired, expiringSecrets[0].Envelope)
+			assert.Equal(t, 3, expiringSecrets[0].Offset)
+			assert.True(t, now.Add(-time.Hour).Equal(expiringSecrets[0].ExpiresAt))
+			assert.Equal(t, expiringSoon, expiringSecrets[1].Envelope)
+		}
+	})
+
+	t.Run("ReturnsExpiryWithoutOpeningValue", func(t *testing.T) {
+
+		now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		observer := &recordingAuditObserver{}
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithClock(func() time.Time { return now }), WithAuditObserver(observer), WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		expiringSoon, err := secretHelper.Encrypt("expiring soon", DefaultPipelineAllowList, ExpiresAt(now.Add(24*time.Hour)))
+		assert.Nil(t, err)
+		other, err := secretHelper.Encrypt("other", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		// a value that doesn't open proves the scan never tries to
+		splittedStrings := strings.Split(expiringSoon, ".")
+		splittedStrings[3] = strings.Split(other, ".")[3]
+		envelope := "ziplinee.secret(" + strings.Join(splittedStrings, ".") + ")"
+		observer.events = nil
+
+		// act
+		expiringSecrets, err := secretHelper.GetExpiringSecrets("a: "+envelope, 30*24*time.Hour)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(expiringSecrets)) {
+			assert.Equal(t, envelope, expiringSecrets[0].Envelope)
+		}
+		if assert.Equal(t, 1, len(observer.events)) {
+			assert.Equal(t, AuditOperationInspect, observer.events[0].Operation)
+			assert.Equal(t, "", observer.events[0].Fingerprint)
+		}
+	})
+
+	t.Run("ReturnsErrorForSecretsThatFailToDecryptAndKeepsScanning", func(t *testing.T) {
+
+		now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithClock(func() time.Time { return now }))
+		expiringSoon, err := secretHelper.EncryptEnvelope("expiring soon", DefaultPipelineAllowList, ExpiresAt(now.Add(time.Hour)))
+		assert.Nil(t, err)
+		input := "a: ziplinee.secret(v2.unknown.ggyRBRZW_ofbXRgl.DdPeqg-ulQEKBuiCC_XZVscTrt4yFRxDE_u_mf8OiNtb)\nb: " + expiringSoon
+
+		// act
+		expiringSecrets, err := secretHelper.GetExpiringSecrets(input, time.Hour*24)
+
+		assert.True(t, errors.Is(err, ErrUnknownKey))
+		assert.Equal(t, 1, len(expiringSecrets))
+	})
+}
+
+// benchmarkManifest returns a multi-megabyte manifest with thousands of envelopes encrypted by the secret helper
+func benchmarkManifest(b *testing.B, secretHelper SecretHelper) string {
+
+	b.Helper()
+
+	var sb strings.Builder
+	for i := 0; i < 5000; i++ {
+		envelope, err := secretHelper.EncryptEnvelope(fmt.Sprintf("this is secret number %v", i), "github.com/ziplineeci/ziplinee-ci-api")
+		if err != nil {
+			b.Fatal(err)
+		}
+		fmt.Fprintf(&sb, "  stage-%v:\n    image: extensions/docker:stable\n    action: build\n    password: %v\n    args:\n%v", i, envelope, strings.Repeat("    - some argument of a build stage that isn't a secret\n", 12))
+	}
+
+	return sb.String()
+}
+
+func BenchmarkDecryptAllEnvelopes(b *testing.B) {
+
+	secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+	manifest := benchmarkManifest(b, secretHelper)
+	b.SetBytes(int64(len(manifest)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := secretHelper.DecryptAllEnvelopes(manifest, "github.com/ziplineeci/ziplinee-ci-api"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecryptAllEnvelopesStream(b *testing.B) {
+
+	secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+	manifest := benchmarkManifest(b, secretHelper)
+	b.SetBytes(int64(len(manifest)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := secretHelper.DecryptAllEnvelopesStream(context.Background(), strings.NewReader(manifest), io.Discard, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReencryptAllEnvelopes(b *testing.B) {
+
+	secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+	manifest := benchmarkManifest(b, secretHelper)
+	b.SetBytes(int64(len(manifest)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := secretHelper.ReencryptAllEnvelopes(manifest, "github.com/ziplineeci/ziplinee-ci-api", false, WithTargetKey("7pB6HcgsYNIVnR6omtM6dHOjzOZwN2Ot", false)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetAllSecretEnvelopes(b *testing.B) {
+
+	secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+	manifest := benchmarkManifest(b, secretHelper)
+	b.SetBytes(int64(len(manifest)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := secretHelper.GetAllSecretEnvelopes(manifest); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
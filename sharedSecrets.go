@@ -0,0 +1,112 @@
+package crypt
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// SharedSecret is a value that's held by more than one envelope, identified by its fingerprint only
+type SharedSecret struct {
+	// Fingerprint is the fingerprint of the value
+	Fingerprint string
+	// Pipelines are the pipelines with a manifest holding the value, in order
+	Pipelines []string
+	// Occurrences are the envelopes holding the value, in order of pipeline and offset
+	Occurrences []SecretOccurrence
+}
+
+// SecretOccurrence is an envelope in the manifest of a pipeline
+type SecretOccurrence struct {
+	// Pipeline is the pipeline of the manifest
+	Pipeline string
+	// Offset is the byte offset of the envelope in the manifest
+	Offset int
+	// Envelope is the secret in its envelope
+	Envelope string
+}
+
+func (sh *secretHelperImpl) GetSharedSecrets(manifests map[string]string, options ...BulkOption) (sharedSecrets []SharedSecret, err error) {
+	return sh.GetSharedSecretsContext(context.Background(), manifests, options...)
+}
+
+func (sh *secretHelperImpl) GetSharedSecretsContext(ctx context.Context, manifests map[string]string, options ...BulkOption) (sharedSecrets []SharedSecret, err error) {
+
+	if sh.fingerprintKey == nil {
+		return nil, ErrNoFingerprintKey
+	}
+
+	o := newBulkOptions(options)
+
+	pipelines := make([]string, 0, len(manifests))
+	for pipeline := range manifests {
+		pipelines = append(pipelines, pipeline)
+	}
+	sort.Strings(pipelines)
+
+	var occurrences []SecretOccurrence
+	for _, pipeline := range pipelines {
+		manifest := manifests[pipeline]
+		for _, loc := range findAllEnvelopes(manifest) {
+			occurrences = append(occurrences, SecretOccurrence{
+				Pipeline: pipeline,
+				Offset:   loc.start,
+				Envelope: manifest[loc.start:loc.end],
+			})
+		}
+	}
+
+	// like FingerprintContext the restrictions of the secrets don't apply, as a value restricted to a branch or past its
+	// expiry leaks just the same; only its fingerprint is kept, and the secrets that fail to decrypt are reported but
+	// don't stop the analysis
+	fingerprints := make([]string, len(occurrences))
+	errs := make([]error, len(occurrences))
+	err = forEachIndex(ctx, len(occurrences), o.workers, func(i int) {
+		encryptedTextPlusNonce, _ := unwrapEnvelope(occurrences[i].Envelope)
+		decryptedText, _, err := sh.decrypt(ctx, AuditOperationInspect, encryptedTextPlusNonce, pipelineScope(occurrences[i].Pipeline), false)
+		if err != nil {
+			errs[i] = secretErrorAt(err, occurrences[i].Envelope, occurrences[i].Offset)
+			return
+		}
+		fingerprints[i] = sh.fingerprint(decryptedText)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string]*SharedSecret{}
+	for i, fingerprint := range fingerprints {
+		if errs[i] != nil {
+			continue
+		}
+		group, ok := groups[fingerprint]
+		if !ok {
+			group = &SharedSecret{Fingerprint: fingerprint}
+			groups[fingerprint] = group
+		}
+		// occurrences are in order of pipeline, so a new pipeline can only follow the last one
+		if len(group.Pipelines) == 0 || group.Pipelines[len(group.Pipelines)-1] != occurrences[i].Pipeline {
+			group.Pipelines = append(group.Pipelines, occurrences[i].Pipeline)
+		}
+		group.Occurrences = append(group.Occurrences, occurrences[i])
+	}
+	for _, group := range groups {
+		if len(group.Occurrences) > 1 {
+			sharedSecrets = append(sharedSecrets, *group)
+		}
+	}
+
+	// the values shared by the most pipelines come first, as they're the worst to leak
+	sort.Slice(sharedSecrets, func(i, j int) bool {
+		a, b := sharedSecrets[i], sharedSecrets[j]
+		if len(a.Pipelines) != len(b.Pipelines) {
+			return len(a.Pipelines) > len(b.Pipelines)
+		}
+		if len(a.Occurrences) != len(b.Occurrences) {
+			return len(a.Occurrences) > len(b.Occurrences)
+		}
+		return a.Fingerprint < b.Fingerprint
+	})
+
+	return sharedSecrets, errors.Join(errs...)
+}
@@ -0,0 +1,138 @@
+package crypt
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSharedSecrets(t *testing.T) {
+
+	t.Run("ReturnsGroupsOfEnvelopesHoldingSameValueAcrossManifests", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		apiEnvelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		webEnvelope, err := secretHelper.EncryptEnvelope("this is my secret", "github.com/ziplineeci/ziplinee-ci-web")
+		assert.Nil(t, err)
+		otherEnvelope, err := secretHelper.EncryptEnvelope("this is my other secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		manifests := map[string]string{
+			"github.com/ziplineeci/ziplinee-ci-web": "token: " + webEnvelope,
+			"github.com/ziplineeci/ziplinee-ci-api": "token: " + apiEnvelope + "\nother: " + otherEnvelope,
+		}
+
+		// act
+		sharedSecrets, err := secretHelper.GetSharedSecrets(manifests)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(sharedSecrets)) {
+			assert.Equal(t, 32, len(sharedSecrets[0].Fingerprint))
+			assert.Equal(t, []string{"github.com/ziplineeci/ziplinee-ci-api", "github.com/ziplineeci/ziplinee-ci-web"}, sharedSecrets[0].Pipelines)
+			assert.Equal(t, []SecretOccurrence{
+				{Pipeline: "github.com/ziplineeci/ziplinee-ci-api", Offset: 7, Envelope: apiEnvelope},
+				{Pipeline: "github.com/ziplineeci/ziplinee-ci-web", Offset: 7, Envelope: webEnvelope},
+			}, sharedSecrets[0].Occurrences)
+		}
+	})
+
+	t.Run("ReturnsValueRepeatedWithinSingleManifest", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		first, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		second, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+
+		// act
+		sharedSecrets, err := secretHelper.GetSharedSecrets(map[string]string{"github.com/ziplineeci/ziplinee-ci-api": first + " " + second}, WithWorkers(2))
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(sharedSecrets)) {
+			assert.Equal(t, []string{"github.com/ziplineeci/ziplinee-ci-api"}, sharedSecrets[0].Pipelines)
+			assert.Equal(t, 2, len(sharedSecrets[0].Occurrences))
+		}
+	})
+
+	t.Run("ReturnsValuesSharedByMostPipelinesFirst", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		manifests := map[string]string{}
+		for _, pipeline := range []string{"github.com/ziplineeci/a", "github.com/ziplineeci/b", "github.com/ziplineeci/c"} {
+			widelyShared, err := secretHelper.EncryptEnvelope("widely shared", DefaultPipelineAllowList)
+			assert.Nil(t, err)
+			manifests[pipeline] = widelyShared
+		}
+		for _, pipeline := range []string{"github.com/ziplineeci/a", "github.com/ziplineeci/b"} {
+			lessShared, err := secretHelper.EncryptEnvelope("less shared", DefaultPipelineAllowList)
+			assert.Nil(t, err)
+			manifests[pipeline] += "\n" + lessShared
+		}
+
+		// act
+		sharedSecrets, err := secretHelper.GetSharedSecrets(manifests)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(sharedSecrets)) {
+			assert.Equal(t, 3, len(sharedSecrets[0].Pipelines))
+			assert.Equal(t, 2, len(sharedSecrets[1].Pipelines))
+		}
+	})
+
+	t.Run("ReturnsValuesOfRestrictedAndExpiredSecrets", func(t *testing.T) {
+
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false), WithClock(func() time.Time { return now }))
+		first, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList, RestrictToBranches("main"))
+		assert.Nil(t, err)
+		second, err := secretHelper.EncryptEnvelope("this is my secret", "github.com/ziplineeci/ziplinee-ci-api", RestrictToBranches("main"), ExpiresAt(now.Add(time.Hour)))
+		assert.Nil(t, err)
+		now = now.Add(time.Hour * 2)
+		manifests := map[string]string{
+			"github.com/ziplineeci/ziplinee-ci-api": "token: " + first,
+			"github.com/ziplineeci/ziplinee-ci-web": "token: " + second,
+		}
+
+		// act
+		sharedSecrets, err := secretHelper.GetSharedSecrets(manifests)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(sharedSecrets)) {
+			assert.Equal(t, []string{"github.com/ziplineeci/ziplinee-ci-api", "github.com/ziplineeci/ziplinee-ci-web"}, sharedSecrets[0].Pipelines)
+		}
+	})
+
+	t.Run("ReturnsErrorsForSecretsThatFailWithoutStopping", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false, WithFingerprintKey("AjfAVq2TwFzrRn9DHSGqXvBg7TmvQJmW", false))
+		malformedEnvelope := "ziplinee.secret(deFTz5Bdjg6SUe29oPIkXbze5G9PNEWS2-ZnArl8BCqHnx4MdTdxHg37th9u)"
+		first, err := secretHelper.EncryptEnvelope("this is my other secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		second, err := secretHelper.EncryptEnvelope("this is my other secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		manifests := map[string]string{
+			"github.com/ziplineeci/ziplinee-ci-api": "a: " + malformedEnvelope + "\nb: " + first,
+			"github.com/ziplineeci/ziplinee-ci-web": "a: " + malformedEnvelope + "\nb: " + second,
+		}
+
+		// act
+		sharedSecrets, err := secretHelper.GetSharedSecrets(manifests)
+
+		assert.True(t, errors.Is(err, ErrMalformedSecret))
+		if assert.Equal(t, 1, len(sharedSecrets)) {
+			assert.Equal(t, first, sharedSecrets[0].Occurrences[0].Envelope)
+		}
+	})
+
+	t.Run("ReturnsErrNoFingerprintKeyWithoutFingerprintKey", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+
+		// act
+		_, err := secretHelper.GetSharedSecrets(map[string]string{})
+
+		assert.True(t, errors.Is(err, ErrNoFingerprintKey))
+	})
+}
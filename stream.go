@@ -0,0 +1,114 @@
+package crypt
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// streamReadSize is the size of the chunks read from the input of the stream methods
+const streamReadSize = 32 * 1024
+
+// maxStreamedEnvelopeLength bounds the memory the stream methods use to hold an envelope that's split across reads;
+// longer envelopes are copied to the output untouched
+const maxStreamedEnvelopeLength = 1024 * 1024
+
+// errAllOrNothingStream is returned by ReencryptAllEnvelopesStream for the AllOrNothing option
+var errAllOrNothingStream = errors.New("AllOrNothing can't be used when streaming, since re-encrypted envelopes are written before the rest of the input is read")
+
+func (sh *secretHelperImpl) DecryptAllEnvelopesStream(ctx context.Context, r io.Reader, w io.Writer, scope Scope, options ...BulkOption) (err error) {
+
+	o := newBulkOptions(options)
+
+	var decryptErrs []error
+	err = replaceAllEnvelopesStream(ctx, r, w, func(encryptedTextInEnvelope string, offset int) string {
+		decryptedText, err := sh.bulkDecryptEnvelope(ctx, encryptedTextInEnvelope, offset, scope, o)
+		if err != nil {
+			decryptErrs = append(decryptErrs, err)
+		}
+		return decryptedText
+	})
+	if err != nil {
+		return
+	}
+
+	return bulkDecryptError(o, decryptErrs)
+}
+
+func (sh *secretHelperImpl) ReencryptAllEnvelopesStream(ctx context.Context, r io.Reader, w io.Writer, pipeline string, base64encodedKey bool, options ...BulkOption) (key string, err error) {
+
+	o := newBulkOptions(options)
+	if o.allOrNothing {
+		return "", errAllOrNothingStream
+	}
+
+	encryptEnvelope, key, err := sh.getReencryptTarget(ctx, o, base64encodedKey)
+	if err != nil {
+		return "", err
+	}
+
+	// envelopes that fail to re-encrypt are written untouched so they're never lost
+	var reencryptErrs []error
+	err = replaceAllEnvelopesStream(ctx, r, w, func(encryptedTextInEnvelope string, offset int) string {
+		envelope := sh.reencryptEnvelope(ctx, encryptedTextInEnvelope, offset, pipeline, encryptEnvelope)
+		if envelope.Err != nil {
+			reencryptErrs = append(reencryptErrs, envelope.Err)
+			return encryptedTextInEnvelope
+		}
+		return envelope.ReencryptedEnvelope
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return key, errors.Join(reencryptErrs...)
+}
+
+// replaceAllEnvelopesStream copies the input to the output, replacing every secret envelope with the return value of
+// replace like replaceAllEnvelopes does; it holds at most a read chunk and an envelope in memory, and stops with the
+// context's error once the context is done, leaving the output incomplete
+func replaceAllEnvelopesStream(ctx context.Context, r io.Reader, w io.Writer, replace func(encryptedTextInEnvelope string, offset int) string) error {
+
+	chunk := make([]byte, streamReadSize)
+	var buf []byte
+	start := 0
+	offset := 0
+	atEOF := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		textEnd, envelopeEnd := nextEnvelope(buf[start:], atEOF, maxStreamedEnvelopeLength)
+		if textEnd > 0 {
+			if _, err := w.Write(buf[start : start+textEnd]); err != nil {
+				return err
+			}
+		}
+		if envelopeEnd > 0 {
+			if _, err := io.WriteString(w, replace(string(buf[start+textEnd:start+envelopeEnd]), offset+start+textEnd)); err != nil {
+				return err
+			}
+			start += envelopeEnd
+			continue
+		}
+		start += textEnd
+
+		if atEOF {
+			return nil
+		}
+
+		// keep the part that may be the start of an envelope and read the next chunk after it
+		buf = append(buf[:0], buf[start:]...)
+		offset += start
+		start = 0
+
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if errors.Is(err, io.EOF) {
+			atEOF = true
+		} else if err != nil {
+			return err
+		}
+	}
+}
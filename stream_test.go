@@ -0,0 +1,113 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptAllEnvelopesStream(t *testing.T) {
+
+	t.Run("ReturnsSameTextAsDecryptAllEnvelopesForEnvelopesSplitAcrossReads", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		input := "ziplinee.secr key1: " + envelope + "\nkey2: ziplinee.secret() ziplinee.secret(abc ziplinee.secret(" + envelope + "\nkey3: " + envelope + "ziplinee.sec"
+		expectedText, err := secretHelper.DecryptAllEnvelopes(input, "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		var output bytes.Buffer
+
+		// act
+		err = secretHelper.DecryptAllEnvelopesStream(context.Background(), iotest.OneByteReader(strings.NewReader(input)), &output, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, expectedText, output.String())
+		assert.Equal(t, 3, strings.Count(output.String(), "this is my secret"))
+	})
+
+	t.Run("ReturnsErrorAtOffsetOfEnvelopeInInput", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", "github.com/ziplineeci/ziplinee-ci-web")
+		assert.Nil(t, err)
+		input := strings.Repeat("a", streamReadSize+10) + envelope
+		var output bytes.Buffer
+
+		// act
+		err = secretHelper.DecryptAllEnvelopesStream(context.Background(), strings.NewReader(input), &output, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"}, KeepUndecryptableEnvelopes())
+
+		var secretErr *SecretError
+		if assert.True(t, errors.As(err, &secretErr)) {
+			assert.Equal(t, streamReadSize+10, secretErr.Offset)
+			assert.True(t, errors.Is(err, ErrRestrictedSecret))
+		}
+		assert.Equal(t, input, output.String())
+	})
+
+	t.Run("CopiesEnvelopeLongerThanMaximumUntouched", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		input := "key: ziplinee.secret(" + strings.Repeat("a", maxStreamedEnvelopeLength) + ")"
+		var output bytes.Buffer
+
+		// act
+		err := secretHelper.DecryptAllEnvelopesStream(context.Background(), strings.NewReader(input), &output, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, input, output.String())
+	})
+
+	t.Run("ReturnsErrorIfContextIsCancelled", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", DefaultPipelineAllowList)
+		assert.Nil(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var output bytes.Buffer
+
+		// act
+		err = secretHelper.DecryptAllEnvelopesStream(ctx, strings.NewReader("key: "+envelope), &output, Scope{Pipeline: "github.com/ziplineeci/ziplinee-ci-api"})
+
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+}
+
+func TestReencryptAllEnvelopesStream(t *testing.T) {
+
+	t.Run("ReturnsKeyThatDecryptsReencryptedEnvelopes", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		envelope, err := secretHelper.EncryptEnvelope("this is my secret", "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		var output bytes.Buffer
+
+		// act
+		key, err := secretHelper.ReencryptAllEnvelopesStream(context.Background(), iotest.HalfReader(strings.NewReader("key1: "+envelope+"\nkey2: "+envelope)), &output, "github.com/ziplineeci/ziplinee-ci-api", false)
+
+		assert.Nil(t, err)
+		assert.NotContains(t, output.String(), envelope)
+		decryptedText, err := NewSecretHelper(key, false).DecryptAllEnvelopes(output.String(), "github.com/ziplineeci/ziplinee-ci-api")
+		assert.Nil(t, err)
+		assert.Equal(t, "key1: this is my secret\nkey2: this is my secret", decryptedText)
+	})
+
+	t.Run("ReturnsErrorForAllOrNothing", func(t *testing.T) {
+
+		secretHelper := NewSecretHelper("SazbwMf3NZxVVbBqQHebPcXCqrVn3DDp", false)
+		var output bytes.Buffer
+
+		// act
+		key, err := secretHelper.ReencryptAllEnvelopesStream(context.Background(), strings.NewReader("key: value"), &output, "github.com/ziplineeci/ziplinee-ci-api", false, AllOrNothing())
+
+		assert.True(t, errors.Is(err, errAllOrNothingStream))
+		assert.Equal(t, "", key)
+		assert.Equal(t, 0, output.Len())
+	})
+}
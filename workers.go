@@ -0,0 +1,47 @@
+package crypt
+
+import (
+	"context"
+	"sync"
+)
+
+// forEachIndex calls fn for every index below n, on up to workers goroutines at a time or one after the other if
+// workers is 1 or less; it stops handing out indexes with the context's error once the context is done, after waiting
+// for the calls in progress
+func forEachIndex(ctx context.Context, n, workers int, fn func(i int)) error {
+
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			fn(i)
+		}
+		return nil
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < n && err == nil; i++ {
+		select {
+		case <-ctx.Done():
+		case indexes <- i:
+		}
+		err = ctx.Err()
+	}
+	close(indexes)
+	wg.Wait()
+
+	return err
+}
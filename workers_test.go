@@ -0,0 +1,72 @@
+package crypt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForEachIndex(t *testing.T) {
+
+	t.Run("CallsFunctionOnceForEveryIndex", func(t *testing.T) {
+
+		var mu sync.Mutex
+		calls := map[int]int{}
+
+		// act
+		err := forEachIndex(context.Background(), 100, 8, func(i int) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls[i]++
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 100, len(calls))
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, 1, calls[i])
+		}
+	})
+
+	t.Run("CallsFunctionForAtMostWorkersIndexesAtATime", func(t *testing.T) {
+
+		var running, maxRunning int32
+
+		// act
+		err := forEachIndex(context.Background(), 50, 4, func(i int) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, maxRunning, int32(4))
+		assert.Greater(t, maxRunning, int32(1))
+	})
+
+	t.Run("ReturnsErrorIfContextIsCancelled", func(t *testing.T) {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var calls int32
+
+		// act
+		err := forEachIndex(ctx, 100, 4, func(i int) {
+			if atomic.AddInt32(&calls, 1) == 10 {
+				cancel()
+			}
+		})
+
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Less(t, atomic.LoadInt32(&calls), int32(100))
+	})
+}